@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Yoosu-L/llmapibenchmark/internal/utils"
+)
+
+// modelComparison is a row of the --benchmark-all-models comparison, taken from the highest concurrency level of a model.
+type modelComparison struct {
+	Model           string
+	Concurrency     int
+	GenerationSpeed float64
+	P95Ttft         float64
+	Error           string // Why the model could not be benchmarked, e.g. an embedding model failing the chat probe
+}
+
+// topLevelComparison returns the comparison row of a benchmarked model, or false if it has no results.
+func topLevelComparison(result BenchmarkResult) (modelComparison, bool) {
+	if len(result.Results) == 0 {
+		return modelComparison{}, false
+	}
+	top := result.Results[0]
+	for _, level := range result.Results[1:] {
+		if level.Concurrency > top.Concurrency {
+			top = level
+		}
+	}
+	return modelComparison{
+		Model:           result.ModelName,
+		Concurrency:     top.Concurrency,
+		GenerationSpeed: top.GenerationSpeed,
+		P95Ttft:         top.P95Ttft,
+	}, true
+}
+
+// modelComparisonTable renders the comparison as a Markdown table, one row per model in benchmark order.
+func modelComparisonTable(rows []modelComparison) string {
+	var b strings.Builder
+	b.WriteString("| Model | Concurrency | Generation Throughput (tokens/s) | P95 TTFT (s) | Status |\n")
+	b.WriteString("|:---|---:|---:|---:|:---|\n")
+	for _, row := range rows {
+		if row.Error != "" {
+			fmt.Fprintf(&b, "| %s | - | - | - | failed: %s |\n", row.Model, strings.ReplaceAll(row.Error, "|", "\\|"))
+			continue
+		}
+		fmt.Fprintf(&b, "| %s | %d | %s | %s | ok |\n", row.Model, row.Concurrency, utils.FormatFloat(row.GenerationSpeed), utils.FormatFloat(row.P95Ttft))
+	}
+	return b.String()
+}
+
+// modelComparisonTitle describes the comparison, normalizedTo is the --normalize-to target or 0.
+func modelComparisonTitle(normalizedTo float64) string {
+	if normalizedTo > 0 {
+		return fmt.Sprintf("Comparison at the highest concurrency level, generation throughput normalized to %g tokens per response:", normalizedTo)
+	}
+	return "Comparison at the highest concurrency level:"
+}
+
+// printModelComparison writes the comparison table to w.
+func printModelComparison(w io.Writer, rows []modelComparison, normalizedTo float64) {
+	fmt.Fprintf(w, "\n%s\n\n%s", modelComparisonTitle(normalizedTo), modelComparisonTable(rows))
+}
+
+// saveModelComparison writes the comparison to API_Throughput_ALL_MODELS.md in dir and returns the file name.
+func saveModelComparison(dir string, rows []modelComparison, normalizedTo float64) (string, error) {
+	filename := filepath.Join(dir, "API_Throughput_ALL_MODELS.md")
+	content := "# All Models\n\n" + modelComparisonTitle(normalizedTo) + "\n\n" + modelComparisonTable(rows)
+	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+		return "", err
+	}
+	return filename, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/Yoosu-L/llmapibenchmark/internal/utils"
+)
+
+// endpointSuffixes are request paths that are sometimes pasted as part of the base URL. The clients
+// append them again, which ends in 404 responses that are easily mistaken for authentication errors.
+var endpointSuffixes = []string{"/chat/completions", "/completions", "/responses", "/models"}
+
+// normalizeBaseURL fixes common mistakes in a base URL and returns the resolved URL together with a
+// warning for every change that was made. Trailing slashes are removed, endpoint paths are stripped and
+// a host without any path gets /v1 appended, except for Triton whose routes start at the root.
+func normalizeBaseURL(raw string, apiKind string) (string, []string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", nil, fmt.Errorf("%q must start with http:// or https://", raw)
+	}
+	if u.Host == "" {
+		return "", nil, fmt.Errorf("%q has no host", raw)
+	}
+
+	var warnings []string
+	path := strings.TrimRight(u.Path, "/")
+	for _, suffix := range endpointSuffixes {
+		if trimmed, ok := strings.CutSuffix(path, suffix); ok {
+			warnings = append(warnings, fmt.Sprintf("removed %s from the base URL, it is added for every request", suffix))
+			path = strings.TrimRight(trimmed, "/")
+			break
+		}
+	}
+	if path == "" && apiKind != utils.APITriton {
+		warnings = append(warnings, "the base URL has no path, assuming /v1")
+		path = "/v1"
+	}
+	u.Path = path
+	u.RawPath = ""
+	return u.String(), warnings, nil
+}
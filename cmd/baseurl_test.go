@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Yoosu-L/llmapibenchmark/internal/utils"
+)
+
+func TestNormalizeBaseURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		apiKind  string
+		want     string
+		warnings int
+	}{
+		{name: "already normalized", raw: "https://api.example.com/v1", apiKind: utils.APIChat, want: "https://api.example.com/v1"},
+		{name: "trailing slash", raw: "https://api.example.com/v1/", apiKind: utils.APIChat, want: "https://api.example.com/v1"},
+		{name: "surrounding whitespace", raw: "  http://localhost:8000/v1 ", apiKind: utils.APIChat, want: "http://localhost:8000/v1"},
+		{name: "chat completions path", raw: "https://api.example.com/v1/chat/completions", apiKind: utils.APIChat, want: "https://api.example.com/v1", warnings: 1},
+		{name: "completions path with slash", raw: "https://api.example.com/v1/completions/", apiKind: utils.APIChat, want: "https://api.example.com/v1", warnings: 1},
+		{name: "responses path", raw: "https://api.example.com/v1/responses", apiKind: utils.APIResponses, want: "https://api.example.com/v1", warnings: 1},
+		{name: "models path", raw: "https://api.example.com/v1/models", apiKind: utils.APIChat, want: "https://api.example.com/v1", warnings: 1},
+		{name: "bare host", raw: "http://localhost:8000", apiKind: utils.APIChat, want: "http://localhost:8000/v1", warnings: 1},
+		{name: "bare host with slash", raw: "http://localhost:8000/", apiKind: utils.APIChat, want: "http://localhost:8000/v1", warnings: 1},
+		{name: "endpoint on the root", raw: "http://localhost:8000/chat/completions", apiKind: utils.APIChat, want: "http://localhost:8000/v1", warnings: 2},
+		{name: "triton root", raw: "http://localhost:8000", apiKind: utils.APITriton, want: "http://localhost:8000"},
+		{name: "triton root with slash", raw: "http://localhost:8000/", apiKind: utils.APITriton, want: "http://localhost:8000"},
+		{name: "path prefix", raw: "https://gateway.example.com/openai", apiKind: utils.APIChat, want: "https://gateway.example.com/openai"},
+		{name: "path prefix with endpoint", raw: "https://gateway.example.com/openai/v1/chat/completions/", apiKind: utils.APIChat, want: "https://gateway.example.com/openai/v1", warnings: 1},
+		{name: "query kept", raw: "https://example.openai.azure.com/openai/deployments/gpt?api-version=2024-06-01", apiKind: utils.APIChat, want: "https://example.openai.azure.com/openai/deployments/gpt?api-version=2024-06-01"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, warnings, err := normalizeBaseURL(tt.raw, tt.apiKind)
+			if err != nil {
+				t.Fatalf("normalizeBaseURL(%q): %v", tt.raw, err)
+			}
+			if got != tt.want {
+				t.Errorf("normalizeBaseURL(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+			if len(warnings) != tt.warnings {
+				t.Errorf("normalizeBaseURL(%q) warned %q, want %d warning(s)", tt.raw, warnings, tt.warnings)
+			}
+		})
+	}
+}
+
+func TestNormalizeBaseURLErrors(t *testing.T) {
+	for _, raw := range []string{
+		"api.example.com/v1",
+		"localhost:8000/v1",
+		"ftp://api.example.com/v1",
+		"http://",
+		"https:///v1",
+		"http://[::1",
+		"",
+	} {
+		if got, _, err := normalizeBaseURL(raw, utils.APIChat); err == nil {
+			t.Errorf("normalizeBaseURL(%q) = %q, want an error", raw, got)
+		}
+	}
+}
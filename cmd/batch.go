@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/Yoosu-L/llmapibenchmark/internal/api"
+	"github.com/Yoosu-L/llmapibenchmark/internal/utils"
+)
+
+// runBatch submits one batch job per concurrency level, using the level as the batch size.
+// A table of the results is rendered to out unless it is nil.
+func (benchmark *Benchmark) runBatch(out io.Writer) (BenchmarkResult, error) {
+	result := benchmark.newResult()
+
+	priced := benchmark.PricePrompt > 0 || benchmark.PriceCompletion > 0
+	if out != nil {
+		fmt.Fprintf(out, "Batch benchmark for %s (poll interval %s)\n\n", benchmark.ModelName, benchmark.BatchPollInterval)
+		if priced {
+			fmt.Fprintln(out, "| Batch Size | Turnaround (s) | Completed | Failed | Prompt Tokens | Completion Tokens | Total TP (tokens/s) |   Cost | Cost / 1M Tokens |  Saved |")
+			fmt.Fprintln(out, "|------------|----------------|-----------|--------|---------------|-------------------|---------------------|--------|------------------|--------|")
+		} else {
+			fmt.Fprintln(out, "| Batch Size | Turnaround (s) | Completed | Failed | Prompt Tokens | Completion Tokens | Total TP (tokens/s) |")
+			fmt.Fprintln(out, "|------------|----------------|-----------|--------|---------------|-------------------|---------------------|")
+		}
+	}
+
+	opts := api.AskOptions{
+		Model:                  benchmark.ModelName,
+		Prompt:                 benchmark.Prompt,
+		MaxTokens:              benchmark.MaxTokens,
+		UseMaxCompletionTokens: benchmark.UseMaxCompletionTokens,
+		NumMessages:            benchmark.NumMessages,
+	}
+	numWords := 0
+	if benchmark.UseRandomInput {
+		numWords = benchmark.NumWords
+	}
+	for _, batchSize := range benchmark.ConcurrencyLevels {
+		batch, err := api.AskOpenAiBatch(benchmark.Client, opts, numWords, batchSize, benchmark.BatchPollInterval)
+		if err != nil {
+			return result, fmt.Errorf("batch size %d: %v", batchSize, err)
+		}
+		batch.Turnaround = utils.RoundToPrecision(batch.Turnaround)
+		batch.TotalThroughput = utils.RoundToPrecision(batch.TotalThroughput)
+		if priced {
+			batch.SetCost(benchmark.PricePrompt, benchmark.PriceCompletion, benchmark.BatchDiscount)
+		}
+		result.BatchResults = append(result.BatchResults, batch)
+
+		if out != nil {
+			fmt.Fprintf(out, "| %10d | %14.2f | %9d | %6d | %13d | %17d | %19.2f |",
+				batch.BatchSize,
+				batch.Turnaround,
+				batch.CompletedRequests,
+				batch.FailedRequests,
+				batch.TotalPromptTokens,
+				batch.TotalCompletionTokens,
+				batch.TotalThroughput,
+			)
+			if priced {
+				fmt.Fprintf(out, " %6.4f | %16.4f | %6.4f |", batch.Cost, batch.CostPerMillionTokens, batch.Savings)
+			}
+			fmt.Fprintln(out)
+		}
+	}
+
+	return result, nil
+}
@@ -2,83 +2,270 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"strings"
 
+	"github.com/Yoosu-L/llmapibenchmark/internal/api"
+	"github.com/Yoosu-L/llmapibenchmark/internal/config"
 	"github.com/Yoosu-L/llmapibenchmark/internal/utils"
 	"github.com/schollz/progressbar/v3"
 )
 
-func (benchmark *Benchmark) runCli() error {
+// runCli runs the benchmark and renders a live table to out.
+func (benchmark *Benchmark) runCli(out io.Writer) (BenchmarkResult, error) {
+	result := benchmark.newResult()
+
 	// Test latency
-	latency, err := utils.MeasureLatency(benchmark.BaseURL, 5)
+	latencyMeasurement, err := utils.MeasureLatencyDetailed(benchmark.BaseURL, 5)
 	if err != nil {
-		return fmt.Errorf("latency test error: %v", err)
+		return result, fmt.Errorf("latency test error: %v", err)
+	}
+	latency := latencyMeasurement.Avg
+	result.Latency = latency
+	if err := benchmark.checkLatencyGate(latency); err != nil {
+		return result, err
 	}
 
 	// Print benchmark header
-	utils.PrintBenchmarkHeader(benchmark.ModelName, benchmark.InputTokens, benchmark.MaxTokens, latency)
+	utils.FprintBenchmarkHeader(out, benchmark.reportTitle(), benchmark.ModelName, benchmark.InputTokens, benchmark.MaxTokens, latencyMeasurement)
+	if benchmark.ReportDescription != "" {
+		fmt.Fprintf(out, "%s\n\n", benchmark.ReportDescription)
+	}
+	if benchmark.DescendingConcurrency {
+		fmt.Fprintf(out, "Running in descending concurrency order: %s\n\n", strings.Join(strings.Fields(strings.Trim(fmt.Sprint(benchmark.ConcurrencyLevels), "[]")), ", "))
+	}
+	if benchmark.NumMessages > 1 {
+		fmt.Fprintf(out, "Messages per request: %d (compare against a run with --num-messages 1 for the single-message baseline)\n\n", benchmark.NumMessages)
+	}
 
-	// Print table header
-	fmt.Println("| C | Gen Speed | Prompt TP | Total TP | Min TTFT | Avg TTFT | Med TTFT | P95 TTFT | P99 TTFT | StdDev | Success | Reqs | Duration |")
-	fmt.Println("|---|-----------|-----------|----------|----------|----------|----------|----------|----------|--------|-------|------|----------|")
+	// Print table header, leaving out less important columns if the terminal is too narrow
+	columns := selectColumns(benchmark.TableWidth, benchmark.ShortHeaders, benchmark.ShowNormalized)
+	fmt.Fprintln(out, tableHeader(columns))
+	fmt.Fprintln(out, tableSeparator(columns))
 
 	// Test each concurrency level and print results
 	var results [][]interface{}
 	for _, concurrency := range benchmark.ConcurrencyLevels {
-		result, err := benchmark.measureSpeed(latency, concurrency, true)
+		measurement, err := benchmark.measureLevel(latency, concurrency, true)
 		if err != nil {
-			return fmt.Errorf("concurrency %d: %v", concurrency, err)
+			return result, fmt.Errorf("concurrency %d: %w", concurrency, err)
 		}
+		result.Results = append(result.Results, measurement)
 
 		// Print current results
-		fmt.Printf("| %2d | %9.2f | %9.2f | %8.2f | %8.2f | %8.2f | %8.2f | %8.2f | %8.2f | %6.2f | %5.2f%% | %4d | %8.2f |\n",
-			concurrency,
-			result.GenerationSpeed,
-			result.PromptThroughput,
-			result.TotalThroughput,
-			result.MinTtft,
-			result.AvgTtft,
-			result.MedianTtft,
-			result.P95Ttft,
-			result.P99Ttft,
-			result.StdDevTtft,
-			result.SuccessRate*100,
-			result.SuccessfulRequests,
-			result.Duration,
-		)
+		fmt.Fprintln(out, tableRow(columns, measurement))
+		for _, endpoint := range measurement.Endpoints {
+			fmt.Fprintf(out, "  %s: %d requests, %s tokens/s, TTFT avg/p95: %s/%s s, success rate %s%%\n", endpoint.BaseUrl, endpoint.Concurrency, utils.FormatFloat(endpoint.GenerationSpeed), utils.FormatFloat(endpoint.AvgTtft), utils.FormatFloat(endpoint.P95Ttft), utils.FormatFloat(endpoint.SuccessRate*100))
+		}
+		if measurement.BurstAvgTtft > 0 || measurement.SustainedAvgTtft > 0 {
+			fmt.Fprintf(out, "  burst TTFT avg/p95: %s/%s s, sustained TTFT avg/p95: %s/%s s\n", utils.FormatFloat(measurement.BurstAvgTtft), utils.FormatFloat(measurement.BurstP95Ttft), utils.FormatFloat(measurement.SustainedAvgTtft), utils.FormatFloat(measurement.SustainedP95Ttft))
+		}
+		if benchmark.DisableKeepAlives {
+			fmt.Fprintf(out, "  connections: %d new, %d reused, %s ms average setup\n", measurement.NewConnections, measurement.ReusedConnections, utils.FormatFloat(measurement.ConnectionSetupMs))
+		}
+		for _, region := range measurement.Regions {
+			fmt.Fprintf(out, "  region %s: %d request(s) (%.0f%%), TTFT avg/p95: %s/%s s\n", region.Region, region.Requests, region.Share*100, utils.FormatFloat(region.AvgTtft), utils.FormatFloat(region.P95Ttft))
+		}
+		if measurement.QueueSamples > 0 {
+			line := fmt.Sprintf("  server queue (%d responses): %s waiting on average (max %.0f)", measurement.QueueSamples, utils.FormatFloat(measurement.AvgQueueDepth), measurement.MaxQueueDepth)
+			if measurement.AvgRunningRequests > 0 {
+				line += fmt.Sprintf(", %s running", utils.FormatFloat(measurement.AvgRunningRequests))
+			}
+			if measurement.AvgKVCacheUsage > 0 {
+				line += fmt.Sprintf(", KV cache %.0f%% used", measurement.AvgKVCacheUsage*100)
+			}
+			fmt.Fprintln(out, line)
+		}
+		for _, interleaved := range measurement.InterleavedResults {
+			fmt.Fprintf(out, "  %s: %d requests, %s tokens/s, TTFT avg/p95: %s/%s s, success rate %s%%\n", measurement.InterleavedModel, interleaved.Concurrency, utils.FormatFloat(interleaved.GenerationSpeed), utils.FormatFloat(interleaved.AvgTtft), utils.FormatFloat(interleaved.P95Ttft), utils.FormatFloat(interleaved.SuccessRate*100))
+		}
+		if measurement.ModelSwitches > 0 {
+			fmt.Fprintf(out, "  model switches (%s): %d, TTFT avg %s s after a switch vs %s s on the same model (penalty %s s), %d of %d TTFT spike(s) after a switch\n", measurement.InterleavedModel, measurement.ModelSwitches, utils.FormatFloat(measurement.SwitchAvgTtft), utils.FormatFloat(measurement.SameModelAvgTtft), utils.FormatFloat(measurement.ModelSwitchPenalty), measurement.SwitchTtftSpikes, measurement.TtftSpikes)
+		}
+		if measurement.NewConnRequests > 0 && measurement.ReusedConnRequests > 0 {
+			fmt.Fprintf(out, "  TTFT avg/p95: %s/%s s on %d new connection(s), %s/%s s on %d reused\n", utils.FormatFloat(measurement.NewConnAvgTtft), utils.FormatFloat(measurement.NewConnP95Ttft), measurement.NewConnRequests, utils.FormatFloat(measurement.ReusedConnAvgTtft), utils.FormatFloat(measurement.ReusedConnP95Ttft), measurement.ReusedConnRequests)
+		}
+		if benchmark.Tokenizer != nil {
+			fmt.Fprintf(out, "  normalized: %d completion tokens, %s tokens/s (API reported %d, %s tokens/s)\n", measurement.NormalizedCompletionTokens, utils.FormatFloat(measurement.NormalizedTokenThroughput), measurement.TotalCompletionTokens, utils.FormatFloat(measurement.GenerationSpeed))
+		}
+		if benchmark.MaxTokens == 0 {
+			fmt.Fprintf(out, "  completion length: %s tokens on average (%s stddev), chosen by the model without a max-tokens limit\n", utils.FormatFloat(measurement.AvgCompletionTokens), utils.FormatFloat(measurement.CompletionLengthStdDev))
+		}
+		if measurement.PartialResponses > 0 {
+			fmt.Fprintf(out, "  %d response(s) cancelled after %d tokens (--read-tokens), left out of the generation speed\n", measurement.PartialResponses, benchmark.ReadTokens)
+		}
+		if benchmark.UniquePrompts {
+			fmt.Fprintf(out, "  prompt tokens: %s avg, %s stddev\n", utils.FormatFloat(measurement.AvgPromptTokens), utils.FormatFloat(measurement.PromptTokensStdDev))
+		}
+		if measurement.ColdTtft > 0 || measurement.WarmTtft > 0 {
+			fmt.Fprintf(out, "  cold connection TTFT: %s s, warm connection TTFT: %s s\n", utils.FormatFloat(measurement.ColdTtft), utils.FormatFloat(measurement.WarmTtft))
+		}
+		if benchmark.Tools != nil {
+			fmt.Fprintf(out, "  tool-call responses: %d of %d\n", measurement.ToolCallResponses, measurement.SuccessfulRequests)
+		}
+		if measurement.TimeoutErrors > 0 {
+			fmt.Fprintf(out, "  timeouts: %d, cancelled after %.0f ms on average\n", measurement.TimeoutErrors, measurement.AvgTimeoutElapsedMs)
+		}
+		if measurement.AbandonedRequests > 0 {
+			fmt.Fprintf(out, "  abandoned at the soft deadline: %d request(s) (%.0f%%), their streamed tokens are counted\n", measurement.AbandonedRequests, measurement.AbandonedRate*100)
+		}
+		if measurement.RateLimitErrors > 0 {
+			fmt.Fprintf(out, "  rate limited: %d request(s) rejected with 429\n", measurement.RateLimitErrors)
+		}
+		for _, warning := range benchmark.levelWarnings(concurrency, measurement, result.Results[:len(result.Results)-1]) {
+			if warning.Serious {
+				fmt.Fprintln(out, utils.Red(warning.Message))
+			} else {
+				fmt.Fprintln(out, utils.Yellow(warning.Message))
+			}
+		}
 
 		// Save results for later
-		results = append(results, []interface{}{
-			concurrency,
-			result.GenerationSpeed,
-			result.PromptThroughput,
-			result.TotalThroughput,
-			result.MinTtft,
-			result.AvgTtft,
-			result.MedianTtft,
-			result.P95Ttft,
-			result.P99Ttft,
-			result.StdDevTtft,
-			result.SuccessRate,
-			result.SuccessfulRequests,
-			result.Duration,
-		})
-	}
-
-	fmt.Println("|---|-----------|-----------|----------|----------|----------|----------|----------|----------|--------|-------|------|----------|")
-	fmt.Println("\n====================================================================================================")
+		results = append(results, markdownRow(concurrency, measurement))
+	}
+
+	// Summarize all levels below the table
+	var summaryRow []interface{}
+	if len(result.Results) > 1 {
+		summary := utils.SummarizeResults(result.Results)
+		fmt.Fprintln(out, tableSeparator(columns))
+		fmt.Fprintln(out, tableRow(columns, summary))
+		fmt.Fprintf(out, "  generation speed min/mean/max: %s/%s/%s tokens/s\n", utils.FormatFloat(summary.MinGenerationSpeed), utils.FormatFloat(summary.GenerationSpeed), utils.FormatFloat(summary.MaxGenerationSpeed))
+		summaryRow = markdownRow("ALL", summary)
+	}
+
+	fmt.Fprintln(out, tableSeparator(columns))
+	fmt.Fprintln(out, "\n====================================================================================================")
 
 	// Save results to Markdown
-	utils.SaveResultsToMD(results, benchmark.ModelName, benchmark.InputTokens, benchmark.MaxTokens, latency)
+	saveResults := utils.SaveResultsToMD
+	if benchmark.AppendResults {
+		saveResults = utils.AppendResultsToMD
+	}
+	if filename := saveResults(results, summaryRow, benchmark.ModelName, benchmark.InputTokens, benchmark.MaxTokens, latency, benchmark.markdownOptions()); filename != "" {
+		fmt.Fprintf(out, "Results saved to: %s\n\n", filename)
+	}
+	if benchmark.WideTable {
+		if filename := utils.SaveWideResultsToMD(result.Results, benchmark.ModelName, benchmark.InputTokens, benchmark.MaxTokens, latency, benchmark.markdownOptions()); filename != "" {
+			fmt.Fprintf(out, "Wide table saved to: %s\n\n", filename)
+		}
+	}
 
-	return nil
+	return result, nil
 }
 
-func (benchmark *Benchmark) run() (BenchmarkResult, error) {
+func (benchmark *Benchmark) markdownOptions() utils.MarkdownOptions {
+	return utils.MarkdownOptions{
+		Highlight:    !benchmark.NoHighlight,
+		ShortHeaders: benchmark.ShortHeaders,
+		Chart:        !benchmark.NoChart,
+		Dir:          benchmark.OutputDir,
+		Title:        benchmark.ReportTitle,
+		Description:  benchmark.ReportDescription,
+	}
+}
+
+// reportTitle returns the title of the banner, --report-title or the default one.
+func (benchmark *Benchmark) reportTitle() string {
+	if benchmark.ReportTitle != "" {
+		return benchmark.ReportTitle
+	}
+	return utils.DefaultReportTitle
+}
+
+// markdownRow returns the values of a Markdown table row in the order expected by utils.SaveResultsToMD.
+func markdownRow(label interface{}, measurement utils.SpeedResult) []interface{} {
+	return []interface{}{
+		label,
+		measurement.GenerationSpeed,
+		measurement.PromptThroughput,
+		measurement.TotalThroughput,
+		measurement.MinTtft,
+		measurement.AvgTtft,
+		measurement.MedianTtft,
+		measurement.P95Ttft,
+		measurement.P99Ttft,
+		measurement.StdDevTtft,
+		measurement.SuccessRate,
+		measurement.SuccessfulRequests,
+		measurement.Duration,
+	}
+}
+
+// probe sends a short request to learn the number of prompt tokens and returns the prompt and completion tokens it used.
+// Random input is probed with a quarter of the words (which makes InputTokens an underestimate), as it always has been.
+func (benchmark *Benchmark) probe() (int, int, error) {
+	opts := api.AskOptions{
+		Model:                  benchmark.ModelName,
+		Prompt:                 benchmark.Prompt,
+		MaxTokens:              4,
+		UseMaxCompletionTokens: benchmark.UseMaxCompletionTokens,
+		NumMessages:            benchmark.NumMessages,
+		MaxRetries:             benchmark.MaxRetries,
+		Tools:                  benchmark.Tools,
+	}
+	var completionTokens, promptTokens int
+	var err error
+	switch {
+	case benchmark.API == utils.APITriton && benchmark.UseRandomInput:
+		_, completionTokens, promptTokens, err = api.AskTritonRandomInput(benchmark.TritonClient, benchmark.NumWords/4, opts)
+	case benchmark.API == utils.APITriton:
+		_, completionTokens, promptTokens, err = api.AskTriton(benchmark.TritonClient, opts)
+	case benchmark.API == utils.APIResponses && benchmark.UseRandomInput:
+		_, completionTokens, promptTokens, err = api.AskOpenAiResponsesRandomInput(benchmark.ResponsesClient, benchmark.NumWords/4, opts)
+	case benchmark.API == utils.APIResponses:
+		_, completionTokens, promptTokens, err = api.AskOpenAiResponses(benchmark.ResponsesClient, opts)
+	case benchmark.UseRandomInput:
+		_, completionTokens, promptTokens, err = api.AskOpenAiRandomInput(benchmark.Client, benchmark.NumWords/4, opts)
+	default:
+		_, completionTokens, promptTokens, err = api.AskOpenAi(benchmark.Client, opts)
+	}
+	return promptTokens, completionTokens, err
+}
+
+func (benchmark *Benchmark) newResult() BenchmarkResult {
 	result := BenchmarkResult{}
 	result.ModelName = benchmark.ModelName
 	result.InputTokens = benchmark.InputTokens
 	result.MaxTokens = benchmark.MaxTokens
+	result.NumMessages = benchmark.NumMessages
+	result.OverheadTokens = benchmark.OverheadTokens
+	return result
+}
+
+// latencyGateError reports an initial latency above --max-initial-latency.
+type latencyGateError struct {
+	Latency   float64 // Milliseconds
+	Threshold float64 // Milliseconds
+}
+
+func (e *latencyGateError) Error() string {
+	return fmt.Sprintf("Initial latency (%.0fms) exceeds gate threshold (%.0fms); aborting. Use --max-initial-latency to adjust or remove this check.", e.Latency, e.Threshold)
+}
+
+// quotaExceededError reports a level at which the server rejected requests because the account ran out of quota or credit.
+type quotaExceededError struct {
+	Concurrency int
+	Rejected    int    // Requests rejected for lack of quota
+	Requests    int    // Requests sent in the run
+	Message     string // Error of the first rejected request
+}
+
+func (e *quotaExceededError) Error() string {
+	return fmt.Sprintf("out of quota/credit: %d of %d requests at concurrency %d were rejected (%s)", e.Rejected, e.Requests, e.Concurrency, e.Message)
+}
+
+// checkLatencyGate returns a latencyGateError if the latency measured before the benchmark exceeds MaxInitialLatency.
+func (benchmark *Benchmark) checkLatencyGate(latency float64) error {
+	if benchmark.MaxInitialLatency > 0 && latency > benchmark.MaxInitialLatency {
+		return &latencyGateError{Latency: latency, Threshold: benchmark.MaxInitialLatency}
+	}
+	return nil
+}
+
+func (benchmark *Benchmark) run() (BenchmarkResult, error) {
+	result := benchmark.newResult()
 
 	// Test latency
 	latency, err := utils.MeasureLatency(benchmark.BaseURL, 5)
@@ -86,11 +273,17 @@ func (benchmark *Benchmark) run() (BenchmarkResult, error) {
 		return result, fmt.Errorf("error testing latency: %v", err)
 	}
 	result.Latency = latency
+	if err := benchmark.checkLatencyGate(latency); err != nil {
+		return result, err
+	}
 
 	for _, concurrency := range benchmark.ConcurrencyLevels {
-		measurement, err := benchmark.measureSpeed(latency, concurrency, false)
+		measurement, err := benchmark.measureLevel(latency, concurrency, false)
 		if err != nil {
-			return result, fmt.Errorf("concurrency %d: %v", concurrency, err)
+			return result, fmt.Errorf("concurrency %d: %w", concurrency, err)
+		}
+		for _, warning := range benchmark.levelWarnings(concurrency, measurement, result.Results) {
+			fmt.Fprintln(os.Stderr, warning.Message)
 		}
 
 		result.Results = append(result.Results, measurement)
@@ -99,20 +292,177 @@ func (benchmark *Benchmark) run() (BenchmarkResult, error) {
 	return result, nil
 }
 
-func (benchmark *Benchmark) measureSpeed(latency float64, concurrency int, clearProgress bool) (utils.SpeedResult, error) {
+// levelWarning is a warning about the results of one concurrency level.
+type levelWarning struct {
+	Message string
+	Serious bool // The results are likely wrong rather than noisy
+}
+
+// levelWarnings returns the warnings about the results of a level, earlier holds the levels measured
+// before it. The table and the machine-readable formats report the same warnings.
+func (benchmark *Benchmark) levelWarnings(concurrency int, measurement utils.SpeedResult, earlier []utils.SpeedResult) []levelWarning {
+	var warnings []levelWarning
+	warn := func(serious bool, format string, args ...interface{}) {
+		warnings = append(warnings, levelWarning{Message: fmt.Sprintf(format, args...), Serious: serious})
+	}
+	if measurement.RunToRunCV > utils.HighRunToRunCV {
+		warn(false, "Warning: concurrency %d (CV=%.2f): %s", concurrency, measurement.RunToRunCV, highVarianceWarning)
+	}
+	if measurement.UnterminatedStreams > 0 {
+		warn(false, "Warning: concurrency %d: %d stream(s) ended without a finish_reason; a proxy or gateway may be truncating responses.", concurrency, measurement.UnterminatedStreams)
+	}
+	if measurement.LatencyAdjustmentSkipped {
+		warn(false, "Warning: concurrency %d: %s", concurrency, latencyAdjustmentWarning)
+	}
+	if cv := ttftCV(measurement); benchmark.WarnOnVariance > 0 && cv > benchmark.WarnOnVariance {
+		warn(false, "Warning: results for concurrency %d are high-variance (TTFT CV=%.2f); consider --repeat.", concurrency, cv)
+	}
+	// Lower levels are expected to be slower when running in descending order
+	if len(earlier) > 0 && !benchmark.DescendingConcurrency && stragglerDrop(earlier[len(earlier)-1], measurement) {
+		warn(false, "Warning: concurrency %d: generation speed dropped while completion lengths vary widely (stddev %s tokens); uneven responses may be holding back batching.", concurrency, utils.FormatFloat(measurement.CompletionLengthStdDev))
+	}
+	if cv := outputTokenCV(measurement); benchmark.WarnOutputVariance > 0 && cv > benchmark.WarnOutputVariance {
+		warn(false, "Warning: concurrency %d: High output token variance detected (CV=%.2f); throughput figures may not be comparable across concurrency levels. Consider using --max-tokens with stop sequences.", concurrency, cv)
+	}
+	if (benchmark.UseRandomInput || benchmark.UniquePrompts) && measurement.DuplicateResponseRate > utils.HighDuplicateResponseRate {
+		warn(true, "Warning: concurrency %d: %.0f%% of the responses are byte-identical to another one despite unique prompts; a caching layer may be returning responses to the wrong requests.", concurrency, measurement.DuplicateResponseRate*100)
+	}
+	if measurement.CacheHitEstimate > 0 {
+		warn(false, "Warning: concurrency %d: %s (%d of %d responses identical)", concurrency, cacheWarning, measurement.CacheHitEstimate, measurement.SuccessfulRequests)
+	}
+	if measurement.P95TtftUnstable {
+		warn(false, "Warning: concurrency %d: P95 TTFT varies across repeats (%s ± %s s); tail latency is unpredictable.", concurrency, utils.FormatFloat(measurement.P95Ttft), utils.FormatFloat(measurement.P95TtftStdDev))
+	}
+	return warnings
+}
+
+// stragglerDrop reports whether the generation speed dropped from the previous level while completion lengths varied widely.
+func stragglerDrop(previous utils.SpeedResult, measurement utils.SpeedResult) bool {
+	if measurement.AvgCompletionTokens <= 0 || measurement.GenerationSpeed >= previous.GenerationSpeed {
+		return false
+	}
+	return measurement.CompletionLengthStdDev/measurement.AvgCompletionTokens > utils.HighCompletionLengthCV
+}
+
+// ttftCV returns the coefficient of variation of the TTFT within a level.
+func ttftCV(measurement utils.SpeedResult) float64 {
+	if measurement.AvgTtft <= 0 {
+		return 0
+	}
+	return measurement.StdDevTtft / measurement.AvgTtft
+}
+
+const latencyAdjustmentWarning = "Network latency is a large share of the request duration and was not subtracted from the throughput window."
+
+const cacheWarning = "Possible server-side caching detected; results may not represent true generation throughput."
+
+const highVarianceWarning = "High inter-run variance detected; results may not be reliable. Consider longer warmup or more stable environment."
+
+// measureLevel runs a concurrency level benchmark.Repeats times and aggregates the runs.
+// Levels found in the checkpoint are returned as is, newly measured levels are added to it.
+func (benchmark *Benchmark) measureLevel(latency float64, concurrency int, clearProgress bool) (utils.SpeedResult, error) {
+	if result, ok := benchmark.Checkpoint[concurrency]; ok {
+		return result, nil
+	}
+	if level, ok := benchmark.PlanLevels[concurrency]; ok {
+		overridden, err := benchmark.withPlanLevel(level)
+		if err != nil {
+			return utils.SpeedResult{}, err
+		}
+		overridden.PlanLevels = nil
+		return overridden.measureLevel(latency, concurrency, clearProgress)
+	}
+
+	repeats := max(1, benchmark.Repeats)
+
+	var runs []utils.SpeedResult
+	for i := 0; i < repeats; i++ {
+		description := fmt.Sprintf("Concurrency %d", concurrency)
+		if repeats > 1 {
+			description = fmt.Sprintf("Concurrency %d (run %d/%d)", concurrency, i+1, repeats)
+		}
+
+		run, err := benchmark.measureSpeed(latency, concurrency, description, clearProgress)
+		if err != nil {
+			return run, err
+		}
+		if run.QuotaErrors > 0 {
+			// Every further request would be rejected as well, the level is not checkpointed
+			return run, &quotaExceededError{Concurrency: concurrency, Rejected: run.QuotaErrors, Requests: concurrency, Message: run.QuotaError}
+		}
+		runs = append(runs, run)
+	}
+
+	result := utils.AggregateResults(runs)
+	if benchmark.CheckpointFile != "" {
+		if err := appendCheckpoint(benchmark.CheckpointFile, result); err != nil {
+			return result, fmt.Errorf("error writing checkpoint: %v", err)
+		}
+	}
+	return result, nil
+}
+
+// withPlanLevel returns a copy of the benchmark with the overrides of a --plan level applied. A prompt
+// override is probed for its InputTokens, and the max-tokens of the level are capped to the context
+// window like the ones of the flags.
+func (benchmark *Benchmark) withPlanLevel(level config.PlanLevel) (*Benchmark, error) {
+	overridden := *benchmark
+	if level.MaxTokens > 0 {
+		overridden.MaxTokens = level.MaxTokens
+	}
+	if level.Repeats > 0 {
+		overridden.Repeats = level.Repeats
+	}
+	if level.Prompt != "" {
+		overridden.Prompt = level.Prompt
+		overridden.UseRandomInput = false
+		promptTokens, _, err := overridden.probe()
+		if err != nil {
+			return nil, fmt.Errorf("concurrency %d: error getting prompt tokens of the plan prompt: %v", level.Concurrency, err)
+		}
+		overridden.InputTokens = promptTokens
+	}
+	if level.MaxTokens > 0 || level.Prompt != "" {
+		overridden.capMaxTokens()
+	}
+	return &overridden, nil
+}
+
+// capMaxTokens keeps prompt and completion within the ContextWindow, with a margin for special tokens.
+// Without a known context window MaxTokens is left unchanged.
+func (benchmark *Benchmark) capMaxTokens() {
+	if benchmark.ContextWindow <= 0 {
+		return
+	}
+	if capped := benchmark.ContextWindow - benchmark.InputTokens - 64; capped > 0 && capped < benchmark.MaxTokens {
+		fmt.Fprintf(os.Stderr, "Adjusted max-tokens from %d to %d based on model context window of %d.\n", benchmark.MaxTokens, capped, benchmark.ContextWindow)
+		benchmark.MaxTokens = capped
+	}
+}
+
+func (benchmark *Benchmark) measureSpeed(latency float64, concurrency int, description string, clearProgress bool) (utils.SpeedResult, error) {
 
 	// Create a progress bar for this specific concurrency level
-	expectedTokens := concurrency * benchmark.MaxTokens
-	bar := progressbar.NewOptions(expectedTokens,
-		progressbar.OptionSetWriter(os.Stderr),
-		progressbar.OptionSetDescription(fmt.Sprintf("Concurrency %d", concurrency)),
-		progressbar.OptionSetWidth(40),
-		progressbar.OptionShowCount(),
-		progressbar.OptionShowIts(),
-		progressbar.OptionSetItsString("tokens"),
-		progressbar.OptionSpinnerType(14),
-		progressbar.OptionSetRenderBlankState(true),
-	)
+	requests, expected, unit := concurrency, concurrency*benchmark.MaxTokens, "tokens"
+	limited := benchmark.MaxTokens > 0
+	if replay := benchmark.Replay[concurrency]; len(replay) > 0 {
+		// Replayed requests carry their own limits
+		requests, expected = len(replay), 0
+		for _, entry := range replay {
+			expected += entry.MaxTokens
+			limited = limited && entry.MaxTokens > 0
+		}
+	}
+	if benchmark.ProgressMode == utils.ProgressRequests {
+		expected, unit = requests, "requests"
+	} else if !limited {
+		// Without a limit the total is unknown, a spinner counts the tokens instead
+		expected = -1
+	}
+	if benchmark.ProgressStyle == utils.ProgressStyleSpinner {
+		expected = -1
+	}
+	bar := benchmark.newProgressBar(expected, description, unit)
 
 	speedMeasurement := utils.SpeedMeasurement{
 		BaseUrl:                benchmark.BaseURL,
@@ -126,11 +476,47 @@ func (benchmark *Benchmark) measureSpeed(latency float64, concurrency int, clear
 		Concurrency:            concurrency,
 		Headers:                benchmark.Headers,
 		UseMaxCompletionTokens: benchmark.UseMaxCompletionTokens,
+		NumMessages:            benchmark.NumMessages,
+		BurstSize:              benchmark.BurstSize,
+		BurstDelay:             benchmark.BurstDelay,
+
+		InjectLatency:            benchmark.InjectLatency,
+		InjectLatencyProbability: benchmark.InjectLatencyProbability,
+		MeasureColdTtft:          benchmark.MeasureColdTtft,
+		MaxRetries:               benchmark.MaxRetries,
+		Timeout:                  utils.ScaleTimeout(benchmark.Timeout, benchmark.TimeoutScale, concurrency),
+		ProgressMode:             benchmark.ProgressMode,
+		ProgressSpinner:          expected == -1,
+		PrewarmConnections:       benchmark.PrewarmConnections,
+		DisableKeepAlives:        benchmark.DisableKeepAlives,
+		ValidateStreams:          benchmark.ValidateStreams,
+		CookieJar:                benchmark.CookieJar,
+		TLSConfig:                benchmark.TLSConfig,
+		Tools:                    benchmark.Tools,
+		API:                      benchmark.API,
+		Recorder:                 benchmark.Recorder,
+		Replay:                   benchmark.Replay[concurrency],
+		IncludeRawData:           benchmark.IncludeRawData,
+		UniquePrompts:            benchmark.UniquePrompts,
+		ReadTokens:               benchmark.ReadTokens,
+		DetectCache:              benchmark.DetectCache,
+		InterleaveModel:          benchmark.InterleaveModel,
+		RegionHeader:             benchmark.RegionHeader,
+		SoftDeadline:             benchmark.SoftDeadline,
+		Tokenizer:                benchmark.Tokenizer,
+		RequestBody:              benchmark.RequestBody,
+		HTTPClient:               benchmark.HTTPClient,
+		PromptSeed:               *benchmark.promptsSent,
+		InputSeed:                benchmark.InputSeed,
+		SampleInterval:           benchmark.SampleInterval,
+		SplitBaseUrl:             benchmark.SplitBaseURL,
+		SplitWeight:              benchmark.SplitWeight,
 	}
 	if benchmark.UseRandomInput {
 		speedMeasurement.UseRandomInput = true
 	}
 
+	*benchmark.promptsSent += int64(concurrency)
 	result, err := speedMeasurement.Run(bar)
 	if err != nil {
 		return result, fmt.Errorf("measurement error: %v", err)
@@ -139,10 +525,53 @@ func (benchmark *Benchmark) measureSpeed(latency float64, concurrency int, clear
 	bar.Finish()
 	if clearProgress {
 		bar.Clear()
-	} else {
+	} else if benchmark.ProgressStyle != utils.ProgressStyleNone {
 		fmt.Fprintf(os.Stderr, "\n")
 	}
 	bar.Close()
 
 	return result, nil
 }
+
+// newProgressBar returns the progress bar of a level in the ProgressStyle of the benchmark. expected is
+// the total in unit, -1 renders a spinner. The style only changes the rendering, never the measurement.
+func (benchmark *Benchmark) newProgressBar(expected int, description string, unit string) *progressbar.ProgressBar {
+	var writer io.Writer = os.Stderr
+	options := []progressbar.Option{
+		progressbar.OptionSetDescription(description),
+		progressbar.OptionSetWidth(40),
+		progressbar.OptionShowCount(),
+		progressbar.OptionShowIts(),
+		progressbar.OptionSetItsString(unit),
+		progressbar.OptionSpinnerType(14),
+		progressbar.OptionSetRenderBlankState(true),
+	}
+	switch benchmark.ProgressStyle {
+	case utils.ProgressStylePercentage:
+		options = append(options, progressbar.OptionSetWidth(0), progressbar.OptionSetTheme(progressbar.Theme{}))
+	case utils.ProgressStyleNone:
+		writer = io.Discard
+	}
+	return progressbar.NewOptions(expected, append(options, progressbar.OptionSetWriter(writer))...)
+}
+
+// outputTokenCV returns the coefficient of variation of the completion lengths of a level.
+func outputTokenCV(measurement utils.SpeedResult) float64 {
+	if measurement.AvgCompletionTokens == 0 {
+		return 0
+	}
+	return measurement.CompletionLengthStdDev / measurement.AvgCompletionTokens
+}
+
+// printQuotaExceeded explains why the run stopped and lists the levels measured before the quota ran out.
+func printQuotaExceeded(w io.Writer, model string, quotaErr *quotaExceededError, result BenchmarkResult) {
+	fmt.Fprintln(w, utils.Red(fmt.Sprintf("Benchmark of %s aborted, the account is out of quota/credit: %d of %d requests at concurrency %d were rejected (%s).", model, quotaErr.Rejected, quotaErr.Requests, quotaErr.Concurrency, quotaErr.Message)))
+	if len(result.Results) == 0 {
+		fmt.Fprintln(w, "No concurrency level completed before running out.")
+		return
+	}
+	fmt.Fprintln(w, "Completed before running out:")
+	for _, level := range result.Results {
+		fmt.Fprintf(w, "  concurrency %d: %s tokens/s, TTFT avg/p95: %s/%s s, %d completion tokens\n", level.Concurrency, utils.FormatFloat(level.GenerationSpeed), utils.FormatFloat(level.AvgTtft), utils.FormatFloat(level.P95Ttft), level.TotalCompletionTokens)
+	}
+}
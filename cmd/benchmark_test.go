@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Yoosu-L/llmapibenchmark/internal/utils"
+)
+
+func TestLevelWarnings(t *testing.T) {
+	tests := []struct {
+		name        string
+		benchmark   Benchmark
+		measurement utils.SpeedResult
+		want        []string
+		serious     bool
+	}{
+		{name: "clean level"},
+		{name: "unterminated streams", measurement: utils.SpeedResult{UnterminatedStreams: 2}, want: []string{"without a finish_reason"}},
+		{
+			name:        "duplicates under unique prompts",
+			benchmark:   Benchmark{UniquePrompts: true},
+			measurement: utils.SpeedResult{DuplicateResponseRate: 0.5},
+			want:        []string{"byte-identical"},
+			serious:     true,
+		},
+		// The same prompt may well get the same answer
+		{name: "duplicates under a fixed prompt", measurement: utils.SpeedResult{DuplicateResponseRate: 0.5}},
+		{
+			name:        "output variance",
+			benchmark:   Benchmark{WarnOutputVariance: 0.3},
+			measurement: utils.SpeedResult{AvgCompletionTokens: 100, CompletionLengthStdDev: 50},
+			want:        []string{"High output token variance"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warnings := tt.benchmark.levelWarnings(4, tt.measurement, nil)
+			if len(warnings) != len(tt.want) {
+				t.Fatalf("levelWarnings = %v, want %d warning(s)", warnings, len(tt.want))
+			}
+			for i, warning := range warnings {
+				if !strings.Contains(warning.Message, tt.want[i]) {
+					t.Errorf("warning %d = %q, want it to mention %q", i, warning.Message, tt.want[i])
+				}
+				if warning.Serious != tt.serious {
+					t.Errorf("warning %d Serious = %v, want %v", i, warning.Serious, tt.serious)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/Yoosu-L/llmapibenchmark/internal/utils"
+)
+
+// loadCheckpoint reads the results of completed concurrency levels from a JSON Lines checkpoint file.
+// A missing file is not an error, it simply means nothing has been completed yet.
+func loadCheckpoint(path string) (map[int]utils.SpeedResult, error) {
+	completed := make(map[int]utils.SpeedResult)
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return completed, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var result utils.SpeedResult
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		completed[result.Concurrency] = result
+	}
+	return completed, scanner.Err()
+}
+
+// appendCheckpoint adds the result of a completed concurrency level to the checkpoint file.
+func appendCheckpoint(path string, result utils.SpeedResult) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	line, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
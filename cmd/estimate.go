@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// TokenEstimate is the token consumption a benchmark run is expected to need.
+type TokenEstimate struct {
+	Requests                    int
+	PromptTokens                int
+	WorstCaseCompletionTokens   int
+	ExpectedCompletionTokens    int
+	WorstCaseCost, ExpectedCost float64
+}
+
+// estimateTokens predicts the token spend of the configured sweep. Completions are expected to
+// run to MaxTokens, except for random input where the model only echoes the prompt back.
+func (benchmark *Benchmark) estimateTokens(pricePromptPerM, priceCompletionPerM float64) TokenEstimate {
+	requestsPerRun := 0
+	for _, concurrency := range benchmark.ConcurrencyLevels {
+		requestsPerRun += concurrency
+		if benchmark.MeasureColdTtft {
+			requestsPerRun += 3 // cold, warm-up and warm request
+		}
+	}
+
+	estimate := TokenEstimate{}
+	estimate.Requests = requestsPerRun * max(1, benchmark.Repeats)
+	estimate.PromptTokens = estimate.Requests * benchmark.InputTokens
+	estimate.WorstCaseCompletionTokens = estimate.Requests * benchmark.MaxTokens
+	expectedPerRequest := benchmark.MaxTokens
+	if benchmark.UseRandomInput {
+		expectedPerRequest = min(benchmark.MaxTokens, benchmark.InputTokens)
+	}
+	estimate.ExpectedCompletionTokens = estimate.Requests * expectedPerRequest
+
+	estimate.WorstCaseCost = (float64(estimate.PromptTokens)*pricePromptPerM + float64(estimate.WorstCaseCompletionTokens)*priceCompletionPerM) / 1e6
+	estimate.ExpectedCost = (float64(estimate.PromptTokens)*pricePromptPerM + float64(estimate.ExpectedCompletionTokens)*priceCompletionPerM) / 1e6
+
+	return estimate
+}
+
+// Print writes the estimate to stderr. Costs are only shown when prices are known.
+func (estimate TokenEstimate) Print(withCost bool) {
+	fmt.Fprintf(os.Stderr, "Estimated token consumption for %d requests:\n", estimate.Requests)
+	fmt.Fprintf(os.Stderr, "  Prompt tokens:               %d\n", estimate.PromptTokens)
+	fmt.Fprintf(os.Stderr, "  Completion tokens (expected): %d\n", estimate.ExpectedCompletionTokens)
+	fmt.Fprintf(os.Stderr, "  Completion tokens (worst):    %d\n", estimate.WorstCaseCompletionTokens)
+	if withCost {
+		fmt.Fprintf(os.Stderr, "  Cost (expected): %.4f\n", estimate.ExpectedCost)
+		fmt.Fprintf(os.Stderr, "  Cost (worst):    %.4f\n", estimate.WorstCaseCost)
+	}
+}
+
+// confirm asks the user on the terminal whether to continue. Without a terminal it declines.
+func confirm(question string) bool {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// parseExpectedThroughput parses a comma-separated list of concurrency=tokens/s pairs, e.g. "1=50,8=300".
+func parseExpectedThroughput(s string) (map[int]float64, error) {
+	expected := make(map[int]float64)
+	for _, pair := range strings.Split(s, ",") {
+		levelStr, speedStr, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid expected throughput '%s', expected concurrency=tokens/s", pair)
+		}
+		level, err := strconv.Atoi(strings.TrimSpace(levelStr))
+		if err != nil || level <= 0 {
+			return nil, fmt.Errorf("invalid concurrency level '%s'", levelStr)
+		}
+		speed, err := strconv.ParseFloat(strings.TrimSpace(speedStr), 64)
+		if err != nil || speed <= 0 {
+			return nil, fmt.Errorf("invalid throughput '%s' for concurrency %d", speedStr, level)
+		}
+		expected[level] = speed
+	}
+	return expected, nil
+}
+
+// throughputRegressions compares the generation speed of every level with its expected value and returns a
+// line for every level that is more than tolerance percent below it, or that was not measured at all.
+func throughputRegressions(result BenchmarkResult, expected map[int]float64, tolerance float64) []string {
+	actual := make(map[int]float64)
+	for _, measurement := range result.Results {
+		actual[measurement.Concurrency] = measurement.GenerationSpeed
+	}
+
+	var regressions []string
+	for _, level := range sortedKeys(expected) {
+		want := expected[level]
+		got, ok := actual[level]
+		if !ok {
+			regressions = append(regressions, fmt.Sprintf("concurrency %d: not measured, expected %.2f tokens/s", level, want))
+			continue
+		}
+		if deviation := (got - want) / want * 100; deviation < -tolerance {
+			regressions = append(regressions, fmt.Sprintf("concurrency %d: %.2f tokens/s, expected %.2f tokens/s (%.1f%%, tolerance %.1f%%)", level, got, want, deviation, tolerance))
+		}
+	}
+	return regressions
+}
+
+func sortedKeys(m map[int]float64) []int {
+	keys := make([]int, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+	return keys
+}
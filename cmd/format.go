@@ -1,13 +1,28 @@
 package main
 
 import (
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"strings"
+
+	"github.com/Yoosu-L/llmapibenchmark/internal/utils"
 
 	"go.yaml.in/yaml/v4"
 )
 
+// Json renders the result as compact JSON for machine consumption.
 func (benchmark *BenchmarkResult) Json() (string, error) {
+	compactJSON, err := json.Marshal(benchmark)
+	if err != nil {
+		return "", fmt.Errorf("error marshalling JSON: %w", err)
+	}
+
+	return string(compactJSON), nil
+}
+
+// JsonPretty renders the result as indented JSON for reading.
+func (benchmark *BenchmarkResult) JsonPretty() (string, error) {
 	prettyJSON, err := json.MarshalIndent(benchmark, "", "    ")
 	if err != nil {
 		return "", fmt.Errorf("error marshalling JSON: %w", err)
@@ -24,3 +39,27 @@ func (benchmark *BenchmarkResult) Yaml() (string, error) {
 
 	return string(yamlData), nil
 }
+
+// Csv renders one row per concurrency level, with the columns of utils.CSVHeader.
+func (benchmark *BenchmarkResult) Csv() (string, error) {
+	var output strings.Builder
+	writer := csv.NewWriter(&output)
+	if err := writer.Write(utils.CSVHeader()); err != nil {
+		return "", fmt.Errorf("error writing CSV: %w", err)
+	}
+	for _, result := range benchmark.Results {
+		record, err := result.MarshalCSV()
+		if err != nil {
+			return "", fmt.Errorf("error marshalling CSV: %w", err)
+		}
+		if err := writer.Write(record); err != nil {
+			return "", fmt.Errorf("error writing CSV: %w", err)
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return "", fmt.Errorf("error writing CSV: %w", err)
+	}
+
+	return strings.TrimSuffix(output.String(), "\n"), nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.yaml.in/yaml/v4"
+)
+
+// loadHeaderFile reads a YAML or JSON file mapping header names to values.
+// Values may use the {api_key} placeholder like --header.
+func loadHeaderFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// JSON is valid YAML, so a single decoder handles both formats
+	var fileHeaders map[string]string
+	if err := yaml.Unmarshal(data, &fileHeaders); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return fileHeaders, nil
+}
+
+// validHeaderName reports whether name is an RFC 7230 token.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c > 127 || !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("!#$%&'*+-.^_`|~", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// setHeader adds a header to the map, warning about names that servers are likely to reject. Names are
+// stored in canonical form, so that x-tenant and X-Tenant replace each other instead of both being sent.
+func setHeader(headers map[string]string, key string, value string) {
+	if !validHeaderName(key) {
+		log.Printf("Warning: header name '%s' is not a valid RFC 7230 token", key)
+	}
+	headers[http.CanonicalHeaderKey(key)] = value
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSetHeaderCanonicalizesNames(t *testing.T) {
+	headers := map[string]string{"Authorization": "Bearer {api_key}"}
+	setHeader(headers, "x-tenant", "from-file")
+	setHeader(headers, "X-Tenant", "from-flag")
+	setHeader(headers, "authorization", "Token abc")
+
+	want := map[string]string{"Authorization": "Token abc", "X-Tenant": "from-flag"}
+	if !reflect.DeepEqual(headers, want) {
+		t.Errorf("headers = %v, want %v", headers, want)
+	}
+}
@@ -2,41 +2,32 @@ package main
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/http/cookiejar"
+	"net/url"
 	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"slices"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/Yoosu-L/llmapibenchmark/internal/api"
+	"github.com/Yoosu-L/llmapibenchmark/internal/config"
+	"github.com/Yoosu-L/llmapibenchmark/internal/exporter"
 	"github.com/Yoosu-L/llmapibenchmark/internal/utils"
 	"github.com/sashabaranov/go-openai"
 	"github.com/spf13/pflag"
+	"golang.org/x/term"
 )
 
-// HeaderTransport is a custom http.RoundTripper that adds custom headers to requests
-type HeaderTransport struct {
-	Base      http.RoundTripper
-	Headers   map[string]string
-	AuthToken string
-}
-
-func (t *HeaderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	// Clone the request to avoid modifying the original
-	newReq := req.Clone(req.Context())
-	
-	// Add custom headers
-	for key, value := range t.Headers {
-		// Replace {api_key} placeholder with actual API key
-		if strings.Contains(value, "{api_key}") {
-			value = strings.ReplaceAll(value, "{api_key}", t.AuthToken)
-		}
-		newReq.Header.Set(key, value)
-	}
-	
-	return t.Base.RoundTrip(newReq)
-}
-
 const (
 	defaultPrompt = "Write a long story, no less than 10,000 words, starting from a long, long time ago."
 )
@@ -44,24 +35,119 @@ const (
 func main() {
 	baseURL := pflag.StringP("base-url", "u", "", "Base URL of the OpenAI API")
 	apiVersion := pflag.StringP("api-version", "v", "", "API version (api-version) query parameter value")
-	apiKey := pflag.StringP("api-key", "k", "", "API key for authentication")
-	model := pflag.StringP("model", "m", "", "Model to be used for the requests (optional)")
+	apiKey := pflag.StringP("api-key", "k", "", "API key for authentication (visible in process listings, prefer --api-key-file or --api-key-env)")
+	apiKeyFile := pflag.String("api-key-file", "", "Read the API key from this file")
+	apiKeyEnv := pflag.String("api-key-env", "", "Read the API key from this environment variable (OPENAI_API_KEY is used if nothing else is set)")
+	model := pflag.StringP("model", "m", "", "Model to be used for the requests (optional, defaults to LLMBENCH_MODEL or the first model the server lists)")
 	prompt := pflag.StringP("prompt", "p", defaultPrompt, "Prompt to be used for generating responses")
 	numWords := pflag.IntP("num-words", "n", 0, "If set to a value above 0 a random string with this length will be used as prompt")
 	concurrencyStr := pflag.StringP("concurrency", "c", "1,2,4,8,16,32,64,128", "Comma-separated list of concurrency levels")
+	planFile := pflag.String("plan", "", "JSON file with the full benchmark plan (levels in order, with optional per-level max-tokens, repeats and prompt); replaces --concurrency and overrides --max-tokens, --repeat, --prompt and --num-words")
+	descendingConcurrency := pflag.Bool("descending-concurrency", false, "Run the concurrency levels from the highest to the lowest (stress first) to observe recovery behavior")
+	promptLengthSweep := pflag.String("prompt-length-sweep", "", "Measure a single concurrency level at each of these comma-separated prompt lengths in tokens (k = 1024, e.g. 1k,4k,16k) instead of sweeping the concurrency")
+	concurrencyMax := pflag.Int("concurrency-max", 0, "Generate the concurrency levels up to this value instead of using --concurrency")
+	concurrencyStep := pflag.String("concurrency-step", "double", "How --concurrency-max levels are generated: 'double' (1,2,4,...), 'linear' (1,2,3,...) or a step size N (N,2N,...)")
+	maxConcurrencyCap := pflag.Int("max-concurrency-cap", 1024, "Concurrency levels above this value are clamped unless --allow-high-concurrency is set")
+	allowHighConcurrency := pflag.Bool("allow-high-concurrency", false, "Allow concurrency levels above --max-concurrency-cap")
 	maxTokens := pflag.IntP("max-tokens", "t", 512, "Maximum number of tokens to generate")
+	numMessages := pflag.Int("num-messages", 1, "Split the prompt across this many alternating user/assistant messages, starting with a system message if even")
+	noMaxTokens := pflag.Bool("no-max-tokens", false, "Send no max-tokens limit and let the model generate until it stops on its own (--max-tokens is ignored)")
 	useMaxCompletionTokens := pflag.Bool("use-max-completion-tokens", false, "Use MaxCompletionTokens instead of MaxTokens (for APIs that don't support both)")
-	format := pflag.StringP("format", "f", "", "Output format (optional)")
+	repeat := pflag.Int("repeat", 1, "Number of times each concurrency level is run; results are aggregated across runs")
+	burstSize := pflag.Int("burst-size", 0, "Number of requests launched at once before the rest of a concurrency level (0 disables the burst phase)")
+	burstDelay := pflag.Duration("burst-delay", 0, "Delay between the burst and the launch of the remaining requests")
+	injectLatency := pflag.Duration("inject-latency", 0, "Sleep this long before sending a benchmark request, to simulate client-side network jitter")
+	injectLatencyProbability := pflag.Float64("inject-latency-probability", 1, "Probability (0-1) that --inject-latency is applied to a request")
+	measureColdTtft := pflag.Bool("measure-cold-ttft", false, "Before each concurrency level, compare TTFT on a freshly dialed connection against a pooled one")
+	softDeadline := pflag.Duration("soft-deadline", 0, "Abandon requests still running this long after they were sent, counting the tokens streamed so far, so a few slow requests cannot stall a level (0 for none)")
+	timeout := pflag.Duration("timeout", 0, "Per-request timeout at concurrency 1, covering the whole streamed response (0 for none)")
+	modelDiscoveryTimeout := pflag.Duration("model-discovery-timeout", 2*modelDiscoveryRetryDelay, "How long to keep retrying model discovery while the API is not ready yet, attempts are 2s apart (0 for a single attempt)")
+	watch := pflag.Duration("watch", 0, "Re-run the benchmark with the same configuration after this interval until interrupted with Ctrl-C, printing the change from the previous run")
+	sampleInterval := pflag.Duration("sample-interval", 0, "Sample the throughput of every concurrency level at this interval and include the time series in JSON/YAML output")
+	normalizeTokens := pflag.String("normalize-tokens", "", "Recount every completion with a local tokenizer ('words' or 'chars') and also report the generation speed in those tokens, for comparisons across providers that count tokens differently")
+	warnOutputVariance := pflag.Float64("warn-output-variance", 0.5, "Warn when the completion lengths of a level vary more than this (stddev over mean), 0 to disable")
+	regionHeader := pflag.String("region-header", "", "Response header naming the region that served a request (e.g. x-served-by or cf-ray); TTFT is then also reported per region")
+	interleaveModel := pflag.String("interleave-model", "", "Send every other request of a level to this second model, report it separately and measure the TTFT penalty of model switches, for servers that load models on demand")
+	detectCache := pflag.Bool("detect-cache", false, "Warn when more than half of a level's responses are identical, a sign of a server-side response cache inflating the throughput")
+	readTokens := pflag.Int("read-tokens", 0, "Cancel every request after this many streamed tokens, for quick TTFT and prefill sweeps (generation speed then only counts complete responses)")
+	inputSeed := pflag.Int64("input-seed", 0, "Seed of the --num-words random input: request i of every level gets the prompt of seed+i, so runs send identical prompts (0 for a different input every run)")
+	uniquePrompts := pflag.Bool("unique-prompts", false, "Send a distinct, reproducible prompt with every request (random input, or a nonce prefix for --prompt) so no request benefits from prompt caching")
+	includeRawData := pflag.Bool("include-raw-data", false, "Include per-request values (e.g. the elapsed time of every timed-out request) in JSON/YAML output")
+	timeoutScale := pflag.Float64("timeout-scale", 0, "Grow the per-request timeout by this fraction of --timeout for every request beyond the first in a level")
+	maxRetries := pflag.Int("max-retries", 2, "Maximum retries for a request that fails with a connection reset before streaming any content")
+	debug := pflag.Bool("debug", false, "Print debug messages (e.g. retry attempts) to stderr")
+	webhookURL := pflag.String("webhook-url", "", "POST a JSON summary (Slack-compatible) to this URL when the benchmark completes or fails")
+	splitBaseURL := pflag.String("split-base-url", "", "Split every concurrency level's requests between --base-url and this second endpoint and report both side by side")
+	splitWeight := pflag.Float64("split-weight", 0.5, "Share of the requests sent to --split-base-url")
+	apiKind := pflag.String("api", utils.APIChat, "API to benchmark: 'chat' (chat completions), 'responses' (Responses API, /responses) or 'triton' (Triton generate_stream, --base-url is the server root)")
+	mode := pflag.String("mode", "chat", "Benchmark mode: 'chat' (streaming chat completions) or 'batch' (batch API jobs, concurrency levels are used as batch sizes)")
+	batchPollInterval := pflag.Duration("batch-poll-interval", 10*time.Second, "How often batch jobs are polled for completion in --mode batch")
+	progressStyle := pflag.String("progress-style", utils.ProgressStyleBar, "Progress bar style: 'bar', 'spinner' (count and rate only), 'percentage' (no bar, for CI logs) or 'none'")
+	progressMode := pflag.String("progress-mode", utils.ProgressTokens, "Progress bar unit: 'tokens' (generated tokens) or 'requests' (completed requests)")
+	noColor := pflag.Bool("no-color", false, "Disable colored terminal output (also honors the NO_COLOR environment variable)")
+	prewarmConnections := pflag.Bool("prewarm-connections", false, "Open one idle connection per request before each concurrency level is timed")
+	disableKeepAlive := pflag.Bool("disable-keepalive", false, "Disable HTTP keep-alive so every request pays for connection setup")
+	validateContentLength := pflag.Bool("validate-content-length", false, "Count streamed responses that end without a finish_reason (truncated by a proxy or gateway)")
+	enableCookies := pflag.Bool("enable-cookies", false, "Keep cookies set by the server (e.g. gateway session cookies) and send them with every request")
+	recordFile := pflag.String("record", "", "Record the prompt, timing and parameters of every request to this JSON Lines trace file")
+	replayFile := pflag.String("replay", "", "Replay the requests of a trace recorded with --record, including their arrival timing")
+	requestFile := pflag.String("request-file", "", "Send the chat completion request body in this JSON file verbatim instead of building it from the flags ({api_key} and ${NAME} are substituted, streaming is forced)")
+	modelsFile := pflag.String("models-file", "", "Benchmark every model listed in this file (one per line, or YAML/JSON with per-model max-tokens and prompt) instead of --model")
+	benchmarkAllModels := pflag.Bool("benchmark-all-models", false, "Benchmark every model listed by the server one after another and print a comparison of their highest concurrency level")
+	checkpointFile := pflag.String("checkpoint-file", "", "Record completed concurrency levels to this JSON Lines file and skip them when resuming")
+	noAutoCap := pflag.Bool("no-auto-cap", false, "Do not lower max-tokens to fit the model's context window")
+	verbose := pflag.Bool("verbose", false, "Print additional details about the setup of the benchmark, and the request body of the first request of each concurrency level, to stderr")
+	toolsFile := pflag.String("tools", "", "JSON file with tool (function) definitions attached to every request")
+	toolChoice := pflag.String("tool-choice", "", "Tool choice for --tools: 'auto', 'none', 'required' or the name of a function")
+	estimate := pflag.Bool("estimate", false, "Print the expected and worst-case token consumption (and cost with prices) and ask for confirmation before running")
+	assumeYes := pflag.BoolP("yes", "y", false, "Do not ask for confirmation after --estimate")
+	pricePrompt := pflag.Float64("price-prompt", 0, "Price per 1M prompt tokens, used by --estimate and to report the cost of --mode batch jobs")
+	priceCompletion := pflag.Float64("price-completion", 0, "Price per 1M completion tokens, used by --estimate and to report the cost of --mode batch jobs")
+	batchDiscount := pflag.Float64("batch-discount", 0.5, "Share of the --price-prompt and --price-completion prices taken off for batch jobs (0.5 for half price)")
+	format := pflag.StringP("format", "f", "", "Output format: json, yaml or csv (optional)")
+	prettyJSONFlag := pflag.Bool("pretty-json", false, "Indent the JSON output of --format json even when stdout is not a terminal")
+	precision := pflag.Int("precision", 2, "Decimal places of the results in the tables and the --format output (0 to 9)")
+	tableWidth := pflag.Int("width", 0, "Fit the results table into this many columns (default: terminal width, full table when not a terminal)")
+	reportTitle := pflag.String("report-title", "", "Title of the banner and H1 of the Markdown report (default \""+utils.DefaultReportTitle+"\")")
+	reportDescription := pflag.String("report-description", "", "Paragraph of context printed before the results table")
+	noChart := pflag.Bool("no-chart", false, "Do not add an ASCII chart of the generation speed to the Markdown file")
+	noHighlight := pflag.Bool("no-highlight", false, "Do not mark the fastest (bold) and least reliable (strikethrough) rows in the Markdown file")
+	warnOnVariance := pflag.Float64("warn-on-variance", 0.5, "Warn when the TTFT coefficient of variation (stddev/mean) of a level exceeds this threshold (0 to disable)")
+	outputDir := pflag.String("output-dir", "", "Collect all artifacts (Markdown, machine output, traces, profiles) in a new timestamped subdirectory of this directory")
+	appendResults := pflag.Bool("append-results", false, "Append the results as a new timestamped section to the Markdown file instead of overwriting it")
+	wideTable := pflag.Bool("wide-table", false, "Also save API_Throughput_<model>_wide.md, a Markdown table with every result field as a column")
+	showNormalized := pflag.Bool("show-normalized", false, "Add the generation speed normalized for the prompt length, GenerationSpeed / (1 + AvgPromptTokens/1000), to the results table")
+	shortHeaders := pflag.Bool("short-headers", false, "Leave the units out of the table headers to save space")
+	outputFile := pflag.StringP("output-file", "o", "", "Write the --format output to this file instead of stdout")
+	normalizeTo := pflag.Float64("normalize-to", 0, "Scale the generation speed in --format output and the --benchmark-all-models comparison to a model that always generates this many tokens per response (0 to disable)")
+	showTable := pflag.Bool("table", false, "With --format, also render the live results table (to stderr) and save the Markdown file")
+	strict := pflag.Bool("strict", false, "Exit non-zero if any request failed, a response was truncated at max_tokens, sent without usage or ended without a finish_reason (implies --validate-content-length), or an --expect-model/--max-latency expectation is violated")
+	expectModel := pflag.String("expect-model", "", "With --strict, the model name the benchmark must run against (e.g. to verify model discovery)")
+	maxInitialLatency := pflag.Float64("max-initial-latency", 0, "Abort with exit code 3 if the latency measured before the benchmark exceeds this many milliseconds (0 disables the check)")
+	maxLatency := pflag.Float64("max-latency", 0, "With --strict, the maximum network latency in milliseconds")
+	expectedThroughput := pflag.String("expected-throughput", "", "Baseline generation speed per level, e.g. '1=50,8=300' (tokens/s); exit non-zero if a level falls more than --throughput-tolerance below it")
+	throughputTolerance := pflag.Float64("throughput-tolerance", 10, "Percent a level may fall below --expected-throughput before the run fails")
+	probe := pflag.Bool("probe", false, "Health check: send a single one-token request and exit 0 if it succeeds within --probe-slo, 1 otherwise. Prints nothing unless --verbose is set")
+	probeSLO := pflag.Duration("probe-slo", 5*time.Second, "Time within which the --probe request has to complete")
+	printOpenAPI := pflag.Bool("print-openapi", false, "Print an OpenAPI 3.0 document describing the JSON result schema and exit")
 	help := pflag.BoolP("help", "h", false, "Show this help message")
 	insecureSkipTLSVerify := pflag.Bool("insecure-skip-tls-verify", false, "Skip TLS certificate verification. Use with caution, this is insecure.")
-	
+	tlsCert := pflag.String("tls-cert", "", "Client certificate (PEM) for mutual TLS, requires --tls-key")
+	tlsKey := pflag.String("tls-key", "", "Client private key (PEM) for mutual TLS, requires --tls-cert")
+	tlsCA := pflag.String("tls-ca", "", "CA bundle (PEM) used to verify the server certificate")
+
 	// Header flags
 	var headers []string
 	pflag.StringArrayVarP(&headers, "header", "H", nil, "Custom headers in 'Key:Value' format. Can be specified multiple times. Use {api_key} placeholder for the API key.")
-	
+
+	// Profiling flags
+	var profiles []string
+	pflag.StringArrayVar(&profiles, "profile", nil, "Write a pprof profile of the benchmarker itself, as 'cpu=path' or 'mem=path'. Can be specified multiple times.")
+
 	// Preset header flags
+	headerFile := pflag.String("header-file", "", "YAML or JSON file mapping header names to values. --header takes precedence. Supports the {api_key} placeholder.")
 	useRooCode := pflag.Bool("roocode", false, "Use RooCode headers (User-Agent: RooCode/3.46.1, Authorization: Bearer {api_key})")
-	
+
 	pflag.Parse()
 
 	if *help {
@@ -70,46 +156,352 @@ func main() {
 		os.Exit(0)
 	}
 
+	if *printOpenAPI {
+		spec, err := openAPISpec()
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		fmt.Print(spec)
+		os.Exit(0)
+	}
+
+	if *noColor {
+		utils.SetColor(false)
+	}
+
+	resolvedKey, err := resolveAPIKey(*apiKey, *apiKeyFile, *apiKeyEnv)
+	if err != nil {
+		log.Fatalf("Error reading API key: %v", err)
+	}
+	*apiKey = resolvedKey
+
+	// Orchestrators often inject the model name, which then avoids a discovery request
+	if *model == "" {
+		*model = os.Getenv("LLMBENCH_MODEL")
+	}
+
+	// A request file fixes the whole request, including the model
+	var requestBody []byte
+	if *requestFile != "" {
+		if *apiKind != utils.APIChat || *mode != "chat" || *modelsFile != "" || *toolsFile != "" || *uniquePrompts || *measureColdTtft {
+			log.Fatalf("--request-file only works with chat completions and cannot be combined with --models-file, --tools, --unique-prompts or --measure-cold-ttft")
+		}
+		requestBody, *model, err = loadRequestFile(*requestFile, *apiKey, *model)
+		if err != nil {
+			log.Fatalf("Error loading request file: %v", err)
+		}
+	}
+
+	if *baseURL == "" {
+		log.Fatalf("--base-url is required")
+	}
+	if *probe {
+		if *model == "" || *apiKind != utils.APIChat || *mode != "chat" {
+			log.Fatalf("--probe requires --model and works with chat completions only")
+		}
+		if *probeSLO <= 0 {
+			log.Fatalf("--probe-slo must be positive")
+		}
+	}
+	// A probe is a silent pass/fail check
+	quiet := *probe && !*verbose
+	for _, flagURL := range []*string{baseURL, splitBaseURL} {
+		if *flagURL == "" {
+			continue
+		}
+		resolved, warnings, err := normalizeBaseURL(*flagURL, *apiKind)
+		if err != nil {
+			log.Fatalf("Invalid base URL: %v", err)
+		}
+		for _, warning := range warnings {
+			if !quiet {
+				log.Printf("Warning: %s", warning)
+			}
+		}
+		*flagURL = resolved
+	}
+	if !quiet {
+		fmt.Fprintf(os.Stderr, "Using base URL: %s\n", *baseURL)
+	}
+
 	// Create benchmark
-	benchmark := Benchmark{}
+	benchmark := Benchmark{promptsSent: new(int64)}
 	benchmark.BaseURL = *baseURL
 	benchmark.ApiVersion = *apiVersion
 	benchmark.ApiKey = *apiKey
 	benchmark.ModelName = *model
+	benchmark.RequestBody = requestBody
 	benchmark.Prompt = *prompt
 	benchmark.NumWords = *numWords
 	benchmark.MaxTokens = *maxTokens
+	if *noMaxTokens {
+		if *apiKind == utils.APITriton || *estimate {
+			log.Fatalf("--no-max-tokens cannot be combined with --api triton or --estimate")
+		}
+		benchmark.MaxTokens = 0
+	}
 	benchmark.UseMaxCompletionTokens = *useMaxCompletionTokens
+	benchmark.NumMessages = *numMessages
+	benchmark.Repeats = *repeat
+	benchmark.BurstSize = *burstSize
+	benchmark.BurstDelay = *burstDelay
+	benchmark.InjectLatency = *injectLatency
+	benchmark.InjectLatencyProbability = *injectLatencyProbability
+	benchmark.MeasureColdTtft = *measureColdTtft
+	benchmark.MaxRetries = *maxRetries
+	benchmark.Timeout = *timeout
+	if *softDeadline < 0 {
+		log.Fatalf("--soft-deadline must not be negative")
+	}
+	benchmark.SoftDeadline = *softDeadline
+	if *modelDiscoveryTimeout < 0 {
+		log.Fatalf("--model-discovery-timeout must not be negative")
+	}
+	benchmark.TimeoutScale = *timeoutScale
+	benchmark.ProgressMode = *progressMode
+	benchmark.ProgressStyle = *progressStyle
+	benchmark.PrewarmConnections = *prewarmConnections
+	benchmark.DisableKeepAlives = *disableKeepAlive
+	// --strict fails on unterminated streams, which are only counted while validating
+	benchmark.ValidateStreams = *validateContentLength || *strict
+	if *toolsFile != "" {
+		tools, err := api.LoadTools(*toolsFile)
+		if err != nil {
+			log.Fatalf("Invalid tools: %v", err)
+		}
+		benchmark.Tools = &api.ToolConfig{Tools: tools, ToolChoice: api.ParseToolChoice(*toolChoice)}
+	} else if *toolChoice != "" {
+		log.Fatalf("--tool-choice requires --tools")
+	}
+	if *verbose {
+		api.VerboseWriter = os.Stderr
+	}
+	if *debug {
+		api.DebugLogger = log.New(os.Stderr, "DEBUG ", log.LstdFlags)
+	}
 
 	// Parse concurrency levels
-	concurrencyLevels, err := utils.ParseConcurrencyLevels(*concurrencyStr)
+	var concurrencyLevels []int
+	if *concurrencyMax != 0 {
+		concurrencyLevels, err = utils.GenerateConcurrencyLevels(*concurrencyMax, *concurrencyStep)
+	} else {
+		concurrencyLevels, err = utils.ParseConcurrencyLevels(*concurrencyStr)
+	}
 	if err != nil {
 		log.Fatalf("Invalid concurrency levels: %v", err)
 	}
+	if *planFile != "" {
+		if *replayFile != "" || *promptLengthSweep != "" || *requestFile != "" {
+			log.Fatalf("--plan cannot be combined with --replay, --prompt-length-sweep or --request-file")
+		}
+		plan, err := config.LoadPlan(*planFile)
+		if err != nil {
+			log.Fatalf("Error loading plan: %v", err)
+		}
+		concurrencyLevels = applyPlan(&benchmark, plan)
+	}
+	if *replayFile != "" {
+		// The trace defines the workload, its levels replace --concurrency
+		trace, err := utils.LoadTrace(*replayFile)
+		if err != nil {
+			log.Fatalf("Error loading trace: %v", err)
+		}
+		if len(trace) == 0 {
+			log.Fatalf("Trace %s contains no requests", *replayFile)
+		}
+		concurrencyLevels = concurrencyLevels[:0]
+		for concurrency := range trace {
+			concurrencyLevels = append(concurrencyLevels, concurrency)
+		}
+		sort.Ints(concurrencyLevels)
+		benchmark.Replay = trace
+	}
+	if *maxConcurrencyCap <= 0 {
+		log.Fatalf("--max-concurrency-cap must be positive")
+	}
+	if !*allowHighConcurrency {
+		// Applied once the levels of a --plan or --replay are known, their overrides move to the clamped level
+		var clamped, replayClamped bool
+		if benchmark.Replay != nil {
+			benchmark.Replay, replayClamped = capReplay(benchmark.Replay, *maxConcurrencyCap)
+		}
+		benchmark.PlanLevels = capPlanLevels(benchmark.PlanLevels, concurrencyLevels, *maxConcurrencyCap)
+		concurrencyLevels, clamped = utils.CapConcurrencyLevels(concurrencyLevels, *maxConcurrencyCap)
+		if (clamped || replayClamped) && !quiet {
+			log.Printf("Warning: concurrency levels above %d were clamped; pass --allow-high-concurrency to run them", *maxConcurrencyCap)
+		}
+	}
+	if fdLimit, ok := utils.FileDescriptorLimit(); ok {
+		highest := uint64(slices.Max(concurrencyLevels))
+		if highest > fdLimit && !quiet {
+			log.Printf("Warning: concurrency %d exceeds the open file limit (%d); requests will fail with 'too many open files'", highest, fdLimit)
+		}
+	}
+	if *descendingConcurrency {
+		slices.Reverse(concurrencyLevels)
+		benchmark.DescendingConcurrency = true
+	}
 	benchmark.ConcurrencyLevels = concurrencyLevels
 
-	// Initialize OpenAI client
-	if *baseURL == "" {
-		log.Fatalf("--base-url is required")
+	if *promptLengthSweep != "" {
+		if *mode == "batch" || *requestFile != "" || *checkpointFile != "" || *replayFile != "" || *numWords != 0 || *prompt != defaultPrompt {
+			log.Fatalf("--prompt-length-sweep generates its own prompts and cannot be combined with --mode batch, --request-file, --checkpoint-file, --replay, --num-words or --prompt")
+		}
+		if pflag.Lookup("concurrency").Changed || *concurrencyMax != 0 {
+			if len(concurrencyLevels) != 1 {
+				log.Fatalf("--prompt-length-sweep runs at a fixed concurrency, pass a single --concurrency level")
+			}
+		} else {
+			benchmark.ConcurrencyLevels = []int{1}
+		}
+		benchmark.PromptLengths, err = parsePromptLengths(*promptLengthSweep)
+		if err != nil {
+			log.Fatalf("Invalid --prompt-length-sweep: %v", err)
+		}
 	}
 
+	if *numMessages <= 0 {
+		log.Fatalf("--num-messages must be positive")
+	}
+	if *repeat <= 0 {
+		log.Fatalf("--repeat must be positive")
+	}
+	if *burstSize < 0 {
+		log.Fatalf("--burst-size must not be negative")
+	}
+	if *mode != "chat" && *mode != "batch" {
+		log.Fatalf("Invalid mode '%s', expected 'chat' or 'batch'", *mode)
+	}
+	if *apiKind != utils.APIChat && *apiKind != utils.APIResponses && *apiKind != utils.APITriton {
+		log.Fatalf("Invalid API '%s', expected 'chat', 'responses' or 'triton'", *apiKind)
+	}
+	if *apiKind != utils.APIChat && (*mode == "batch" || *measureColdTtft) {
+		log.Fatalf("--api %s cannot be combined with --mode batch or --measure-cold-ttft", *apiKind)
+	}
+	if *benchmarkAllModels && (*model != "" || *modelsFile != "" || *requestFile != "" || *checkpointFile != "" || *apiKind == utils.APITriton) {
+		log.Fatalf("--benchmark-all-models cannot be combined with --model, --models-file, --request-file, --checkpoint-file or --api triton")
+	}
+	if *apiKind == utils.APITriton && (*model == "" || *modelsFile != "" || *toolsFile != "") {
+		log.Fatalf("--api triton requires --model and cannot be combined with --models-file or --tools")
+	}
+	benchmark.API = *apiKind
+	if *progressMode != utils.ProgressTokens && *progressMode != utils.ProgressRequests {
+		log.Fatalf("Invalid progress mode '%s', expected 'tokens' or 'requests'", *progressMode)
+	}
+	switch *progressStyle {
+	case utils.ProgressStyleBar, utils.ProgressStyleSpinner, utils.ProgressStylePercentage, utils.ProgressStyleNone:
+	default:
+		log.Fatalf("Invalid progress style '%s', expected 'bar', 'spinner', 'percentage' or 'none'", *progressStyle)
+	}
+	if *maxRetries < 0 {
+		log.Fatalf("--max-retries must not be negative")
+	}
+	if *injectLatencyProbability < 0 || *injectLatencyProbability > 1 {
+		log.Fatalf("--inject-latency-probability must be between 0 and 1")
+	}
+	if *splitWeight < 0 || *splitWeight > 1 {
+		log.Fatalf("--split-weight must be between 0 and 1")
+	}
+	if *precision < 0 || *precision > 9 {
+		log.Fatalf("--precision must be between 0 and 9")
+	}
+	utils.Precision = *precision
+	if *splitBaseURL != "" && *mode == "batch" {
+		log.Fatalf("--split-base-url cannot be combined with --mode batch")
+	}
+	if *mode == "batch" && (*repeat > 1 || *planFile != "") {
+		log.Fatalf("--mode batch submits one job per batch size and cannot be combined with --repeat or --plan")
+	}
+	if *batchDiscount < 0 || *batchDiscount > 1 {
+		log.Fatalf("--batch-discount must be between 0 and 1")
+	}
+	benchmark.PricePrompt, benchmark.PriceCompletion, benchmark.BatchDiscount = *pricePrompt, *priceCompletion, *batchDiscount
+	benchmark.SplitBaseURL = *splitBaseURL
+	if *interleaveModel != "" {
+		if *mode == "batch" || *requestFile != "" || *replayFile != "" || *splitBaseURL != "" {
+			log.Fatalf("--interleave-model cannot be combined with --mode batch, --request-file, --replay or --split-base-url")
+		}
+		if *interleaveModel == *model {
+			log.Fatalf("--interleave-model must name a different model than --model")
+		}
+	}
+	benchmark.IncludeRawData = *includeRawData
+	benchmark.UniquePrompts = *uniquePrompts
+	benchmark.InputSeed = *inputSeed
+	if *readTokens < 0 {
+		log.Fatalf("--read-tokens must not be negative")
+	}
+	benchmark.ReadTokens = *readTokens
+	benchmark.RegionHeader = *regionHeader
+	benchmark.DetectCache = *detectCache
+	benchmark.InterleaveModel = *interleaveModel
+	if *warnOutputVariance < 0 {
+		log.Fatalf("--warn-output-variance must not be negative")
+	}
+	benchmark.WarnOutputVariance = *warnOutputVariance
+	if *normalizeTokens != "" {
+		benchmark.Tokenizer, err = api.NewTokenizer(*normalizeTokens)
+		if err != nil {
+			log.Fatalf("Invalid --normalize-tokens: %v", err)
+		}
+	}
+	if *sampleInterval < 0 {
+		log.Fatalf("--sample-interval must not be negative")
+	}
+	benchmark.SampleInterval = *sampleInterval
+	if *outputFile != "" && *format == "" {
+		log.Fatalf("--output-file requires --format")
+	}
+	var expectedSpeeds map[int]float64
+	if *expectedThroughput != "" {
+		expectedSpeeds, err = parseExpectedThroughput(*expectedThroughput)
+		if err != nil {
+			log.Fatalf("Invalid --expected-throughput: %v", err)
+		}
+	}
+	if !*strict && (*expectModel != "" || *maxLatency > 0) {
+		log.Fatalf("--expect-model and --max-latency require --strict")
+	}
+	if *watch > 0 && *checkpointFile != "" {
+		log.Fatalf("--watch cannot be combined with --checkpoint-file")
+	}
+	benchmark.SplitWeight = *splitWeight
+	if *maxInitialLatency < 0 {
+		log.Fatalf("--max-initial-latency must not be negative")
+	}
+	benchmark.MaxInitialLatency = *maxInitialLatency
+
+	// Initialize OpenAI client
 	// Build headers map
 	benchmark.Headers = make(map[string]string)
-	
+
 	// Apply preset headers first (RooCode)
 	if *useRooCode {
 		benchmark.Headers["User-Agent"] = "RooCode/3.46.1"
 		benchmark.Headers["Authorization"] = "Bearer {api_key}"
 	}
-	
-	// Apply custom headers (they can override presets)
+
+	if *disableKeepAlive {
+		benchmark.Headers["Connection"] = "close"
+	}
+
+	// Apply headers from the header file, then the --header flags (both can override presets)
+	if *headerFile != "" {
+		fileHeaders, err := loadHeaderFile(*headerFile)
+		if err != nil {
+			log.Fatalf("Error loading header file: %v", err)
+		}
+		for key, value := range fileHeaders {
+			setHeader(benchmark.Headers, key, value)
+		}
+	}
 	for _, header := range headers {
 		parts := strings.SplitN(header, ":", 2)
 		if len(parts) == 2 {
 			key := strings.TrimSpace(parts[0])
 			value := strings.TrimSpace(parts[1])
-			benchmark.Headers[key] = value
-		} else {
+			setHeader(benchmark.Headers, key, value)
+		} else if !quiet {
 			log.Printf("Warning: Invalid header format '%s', expected 'Key:Value'", header)
 		}
 	}
@@ -119,91 +511,591 @@ func main() {
 	config.APIVersion = *apiVersion
 
 	// Setup HTTP client with custom headers
-	var baseTransport http.RoundTripper
-	if *insecureSkipTLSVerify {
-		fmt.Fprintln(os.Stderr, "\n/!\\ WARNING: Skipping TLS certificate verification. This is insecure and should not be used in production. /!\\")
+	tlsConfig, err := buildTLSConfig(*insecureSkipTLSVerify, *tlsCert, *tlsKey, *tlsCA)
+	if err != nil {
+		log.Fatalf("Error configuring TLS: %v", err)
+	}
+	benchmark.TLSConfig = tlsConfig
 
+	var baseTransport http.RoundTripper
+	if tlsConfig != nil || *disableKeepAlive || *prewarmConnections {
 		// Clone the default Transport to preserve its settings
 		defaultTransport, ok := http.DefaultTransport.(*http.Transport)
 		if !ok {
 			log.Fatalf("http.DefaultTransport is not an *http.Transport")
 		}
 		tr := defaultTransport.Clone()
-		tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		tr.TLSClientConfig = tlsConfig
+		tr.DisableKeepAlives = *disableKeepAlive
+		if *prewarmConnections {
+			// The benchmark sends through this transport, its pool must hold a connection per request
+			tr.MaxIdleConnsPerHost = max(tr.MaxIdleConnsPerHost, slices.Max(concurrencyLevels))
+		}
 		baseTransport = tr
 	} else {
 		baseTransport = http.DefaultTransport
 	}
 
+	// The benchmark adds headers and the request body itself, it only shares the connection pool
+	benchmark.HTTPClient = &http.Client{Transport: baseTransport}
+
 	// Wrap transport with custom headers if any are specified
 	if len(benchmark.Headers) > 0 {
-		baseTransport = &HeaderTransport{
+		baseTransport = &utils.HeaderTransport{
 			Base:      baseTransport,
 			Headers:   benchmark.Headers,
 			AuthToken: *apiKey,
 		}
 	}
-	config.HTTPClient = &http.Client{Transport: baseTransport}
+	if requestBody != nil {
+		baseTransport = &api.RequestBodyTransport{Base: baseTransport, Body: requestBody}
+	}
+	httpClient := &http.Client{Transport: baseTransport}
+	config.HTTPClient = httpClient
+
+	// Share one cookie jar between the probe request and the benchmark so session cookies are replayed
+	if *enableCookies {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			log.Fatalf("Error creating cookie jar: %v", err)
+		}
+		httpClient.Jar = jar
+		benchmark.CookieJar = jar
+	}
 
 	client := openai.NewClientWithConfig(config)
+	benchmark.Client = client
+	benchmark.ResponsesClient = &api.ResponsesClient{HTTPClient: httpClient, BaseURL: *baseURL, APIKey: *apiKey, APIVersion: *apiVersion}
+	benchmark.TritonClient = &api.TritonClient{HTTPClient: httpClient, BaseURL: *baseURL, APIKey: *apiKey}
+	benchmark.BatchPollInterval = *batchPollInterval
 
-	// Discover model name if not provided
-	if *model == "" {
-		discoveredModel, err := api.GetFirstAvailableModel(client)
+	if *probe {
+		elapsed, err := runProbe(config, httpClient, *model, *probeSLO)
+		if err != nil {
+			if *verbose {
+				fmt.Fprintf(os.Stderr, "Probe failed after %s: %v\n", elapsed.Round(time.Millisecond), err)
+			}
+			os.Exit(1)
+		}
+		if *verbose {
+			fmt.Fprintf(os.Stderr, "Probe succeeded in %s\n", elapsed.Round(time.Millisecond))
+		}
+		os.Exit(0)
+	}
+
+	// A models file replaces --model, each entry may override max-tokens and prompt
+	models := []modelSpec{{Name: *model}}
+	if *modelsFile != "" {
+		if *checkpointFile != "" {
+			log.Fatalf("--checkpoint-file cannot be combined with --models-file")
+		}
+		models, err = loadModelsFile(*modelsFile)
+		if err != nil {
+			log.Fatalf("Error loading models file: %v", err)
+		}
+		if err := validateModels(client, models); err != nil {
+			log.Fatalf("Error validating models file: %v", err)
+		}
+	}
+
+	if *benchmarkAllModels {
+		ids, err := api.ListModelIDs(client)
 		if err != nil {
-			log.Printf("Error discovering model: %v", err)
+			log.Fatalf("Error listing models: %v", err)
+		}
+		if len(ids) == 0 {
+			log.Fatalf("Error listing models: no models available")
+		}
+		models = models[:0]
+		for _, id := range ids {
+			models = append(models, modelSpec{Name: id})
+		}
+		fmt.Fprintf(os.Stderr, "Benchmarking %d models: %s\n", len(ids), strings.Join(ids, ", "))
+	}
+
+	// Relative artifact paths are placed in the run directory
+	var runDir string
+	if *outputDir != "" {
+		runDir = filepath.Join(*outputDir, time.Now().Format("20060102-150405"))
+		if err := os.MkdirAll(runDir, 0755); err != nil {
+			log.Fatalf("Error creating output directory: %v", err)
+		}
+		benchmark.OutputDir = runDir
+		*recordFile = artifactPath(runDir, *recordFile)
+		*outputFile = artifactPath(runDir, *outputFile)
+		for i, spec := range profiles {
+			if kind, path, ok := strings.Cut(spec, "="); ok {
+				profiles[i] = kind + "=" + artifactPath(runDir, path)
+			}
+		}
+	}
+
+	if *recordFile != "" {
+		recorder, err := utils.NewTraceRecorder(*recordFile)
+		if err != nil {
+			log.Fatalf("Error creating trace file: %v", err)
+		}
+		benchmark.Recorder = recorder
+	}
+
+	// JSON on stdout is indented for people and compact for pipes
+	prettyJSON := *prettyJSONFlag || term.IsTerminal(int(os.Stdout.Fd()))
+
+	// The table goes to stdout unless stdout carries machine-readable output
+	var tableOut io.Writer = os.Stdout
+	if *format != "" {
+		tableOut = nil
+		if *showTable {
+			tableOut = os.Stderr
+		}
+	}
+	benchmark.TableWidth = *tableWidth
+	benchmark.NoHighlight = *noHighlight
+	benchmark.NoChart = *noChart
+	benchmark.ShortHeaders = *shortHeaders
+	benchmark.ShowNormalized = *showNormalized
+	benchmark.AppendResults = *appendResults
+	benchmark.WideTable = *wideTable
+	benchmark.ReportTitle = *reportTitle
+	benchmark.ReportDescription = *reportDescription
+	benchmark.WarnOnVariance = *warnOnVariance
+	if tableFile, ok := tableOut.(*os.File); ok && benchmark.TableWidth == 0 && term.IsTerminal(int(tableFile.Fd())) {
+		benchmark.TableWidth = utils.GetTerminalWidth()
+	}
+
+	stopProfiling, err := startProfiling(profiles)
+	if err != nil {
+		log.Fatalf("Error starting profiler: %v", err)
+	}
+
+	// Every model is reported to the webhook, failed ones too
+	notifyWebhook := func(model string, results []utils.SpeedResult, err error) {
+		if *webhookURL == "" {
 			return
 		}
-		benchmark.ModelName = discoveredModel
+		summary := exporter.NewSummary(model, results, err)
+		if notifyErr := exporter.NotifyWebhook(*webhookURL, summary); notifyErr != nil {
+			log.Printf("Warning: webhook notification failed: %v", notifyErr)
+		}
 	}
 
-	// Determine input parameters and call benchmark function
-	if *prompt != "Write a long story, no less than 10,000 words, starting from a long, long time ago." {
-		benchmark.UseRandomInput = false
-	} else if *numWords != 0 {
-		benchmark.UseRandomInput = true
-	} else {
-		benchmark.UseRandomInput = false
+	// With --watch, the whole benchmark is repeated until interrupted
+	failed := false
+	gated := false // The latency gate tripped, exit with 3 once cleaned up
+	previous := make(map[string]utils.SpeedResult)
+sweep:
+	for watchRun := 1; ; watchRun++ {
+		var comparisons []modelComparison
+		for _, spec := range models {
+			benchmark := benchmark
+			benchmark.ModelName = spec.Name
+			if spec.MaxTokens > 0 {
+				benchmark.MaxTokens = spec.MaxTokens
+			}
+			if spec.Prompt != "" {
+				benchmark.Prompt = spec.Prompt
+			}
+
+			// Discover model name if not provided
+			if benchmark.ModelName == "" {
+				discoveredModel, err := discoverModel(client, *modelDiscoveryTimeout)
+				if err != nil {
+					log.Printf("Error discovering model: %v", err)
+					var discoveryErr *api.ErrModelDiscoveryFailed
+					if errors.As(err, &discoveryErr) && discoveryErr.StatusCode == http.StatusNotFound {
+						log.Printf("The server does not implement /models; pass --model to name the model explicitly")
+					}
+					notifyWebhook("", nil, err)
+					failed = true
+					break sweep
+				}
+				benchmark.ModelName = discoveredModel
+			}
+
+			// Determine input parameters and call benchmark function
+			if benchmark.Prompt != defaultPrompt {
+				benchmark.UseRandomInput = false
+			} else if benchmark.NumWords != 0 {
+				benchmark.UseRandomInput = true
+			} else {
+				benchmark.UseRandomInput = false
+			}
+			words := len(strings.Fields(benchmark.Prompt))
+			if benchmark.UseRandomInput {
+				words = benchmark.NumWords
+			}
+			if watchRun == 1 && benchmark.NumMessages > 1 && words < benchmark.NumMessages {
+				log.Printf("Warning: the prompt of %s has %d word(s), fewer than --num-messages %d; it is sent as a single message", benchmark.ModelName, words, benchmark.NumMessages)
+			}
+
+			// Get input tokens
+			promptTokens, completionTokens, err := benchmark.probe()
+			if err != nil {
+				notifyWebhook(benchmark.ModelName, nil, err)
+				if *benchmarkAllModels {
+					// /models also lists embedding, audio and moderation models, which cannot be chat-benchmarked
+					log.Printf("Skipping %s, it failed the chat probe: %v", benchmark.ModelName, err)
+					comparisons = append(comparisons, modelComparison{Model: benchmark.ModelName, Error: err.Error()})
+					continue
+				}
+				log.Printf("Error getting prompt tokens for %s: %v", benchmark.ModelName, err)
+				failed = true
+				continue
+			}
+			benchmark.InputTokens = promptTokens
+			benchmark.OverheadTokens = promptTokens + completionTokens
+
+			if *verbose && benchmark.CookieJar != nil {
+				if u, err := url.Parse(*baseURL); err == nil {
+					for _, cookie := range benchmark.CookieJar.Cookies(u) {
+						log.Printf("Received cookie: %s", cookie.Name)
+					}
+				}
+			}
+
+			// Keep prompt and completion within the model's context window, with a margin for special tokens
+			if !*noAutoCap {
+				contextWindow, err := api.GetModelContextWindow(httpClient, *baseURL, *apiKey, benchmark.ModelName)
+				if err != nil {
+					if *verbose {
+						log.Printf("Not capping max-tokens: %v", err)
+					}
+				} else {
+					benchmark.ContextWindow = contextWindow
+					benchmark.capMaxTokens()
+				}
+			}
+
+			if *estimate && watchRun == 1 {
+				benchmark.estimateTokens(*pricePrompt, *priceCompletion).Print(*pricePrompt > 0 || *priceCompletion > 0)
+				if !*assumeYes && !confirm("Run the benchmark?") {
+					fmt.Fprintln(os.Stderr, "Aborted, pass --yes to run without confirmation.")
+					failed = true
+					break sweep
+				}
+			}
+
+			if *checkpointFile != "" {
+				completed, err := loadCheckpoint(*checkpointFile)
+				if err != nil {
+					log.Fatalf("Error loading checkpoint: %v", err)
+				}
+				var done []int
+				for _, concurrency := range benchmark.ConcurrencyLevels {
+					if _, ok := completed[concurrency]; ok {
+						done = append(done, concurrency)
+					}
+				}
+				if len(done) > 0 {
+					fmt.Fprintf(os.Stderr, "Resuming from checkpoint: concurrency levels %s already done.\n", strings.Join(strings.Fields(fmt.Sprint(done)), ","))
+				}
+				benchmark.CheckpointFile = *checkpointFile
+				benchmark.Checkpoint = completed
+			}
+
+			var result BenchmarkResult
+			switch {
+			case *mode == "batch":
+				result, err = benchmark.runBatch(tableOut)
+			case len(benchmark.PromptLengths) > 0:
+				result, err = benchmark.runPromptLengthSweep(tableOut)
+			case tableOut != nil:
+				result, err = benchmark.runCli(tableOut)
+			default:
+				result, err = benchmark.run()
+			}
+
+			// Notify before exiting so failed runs are reported too
+			notifyWebhook(benchmark.ModelName, result.Results, err)
+
+			// A pre-degraded API makes the results of every model misleading
+			var gateErr *latencyGateError
+			if errors.As(err, &gateErr) {
+				fmt.Fprintln(os.Stderr, utils.Red(gateErr.Error()))
+				gated = true
+				break sweep
+			}
+
+			// The quota is shared by all models, every further request would be rejected
+			var quotaErr *quotaExceededError
+			if errors.As(err, &quotaErr) {
+				printQuotaExceeded(os.Stderr, benchmark.ModelName, quotaErr, result)
+				failed = true
+				break sweep
+			}
+
+			// A failing model does not stop the remaining ones
+			if err != nil {
+				log.Printf("Error running benchmark for %s: %v", benchmark.ModelName, err)
+				if *benchmarkAllModels {
+					comparisons = append(comparisons, modelComparison{Model: benchmark.ModelName, Error: err.Error()})
+				}
+				failed = true
+				continue
+			}
+
+			if *strict {
+				violations := strictViolations(result, strictExpectations{Model: *expectModel, MaxLatency: *maxLatency})
+				for _, violation := range violations {
+					fmt.Fprintln(os.Stderr, utils.Red(fmt.Sprintf("Strict check failed for %s: %s", benchmark.ModelName, violation)))
+				}
+				if len(violations) > 0 {
+					failed = true
+				}
+			}
+
+			if expectedSpeeds != nil {
+				regressions := throughputRegressions(result, expectedSpeeds, *throughputTolerance)
+				for _, regression := range regressions {
+					fmt.Fprintln(os.Stderr, utils.Red(fmt.Sprintf("Below expected throughput for %s: %s", benchmark.ModelName, regression)))
+				}
+				if len(regressions) > 0 {
+					failed = true
+				}
+			}
+
+			if *benchmarkAllModels {
+				compared := result
+				if *normalizeTo > 0 {
+					compared = result.Normalize(*normalizeTo)
+				}
+				if comparison, ok := topLevelComparison(compared); ok {
+					comparisons = append(comparisons, comparison)
+				}
+			}
+
+			if *watch > 0 && len(result.Results) > 0 {
+				summary := utils.SummarizeResults(result.Results)
+				if last, ok := previous[benchmark.ModelName]; ok {
+					printRunDelta(os.Stderr, benchmark.ModelName, last, summary)
+				}
+				previous[benchmark.ModelName] = summary
+			}
+
+			if *format != "" {
+				if *normalizeTo > 0 {
+					result = result.Normalize(*normalizeTo)
+				}
+				if *outputFile != "" {
+					path := *outputFile
+					if len(models) > 1 {
+						ext := filepath.Ext(path)
+						path = strings.TrimSuffix(path, ext) + "_" + utils.SafeModelName(benchmark.ModelName) + ext
+					}
+					if err := saveResult(result, *format, path); err != nil {
+						log.Printf("Error saving results: %v", err)
+						failed = true
+					} else {
+						fmt.Fprintf(os.Stderr, "Results saved to: %s\n", path)
+					}
+					continue
+				}
+
+				var output string
+				switch *format {
+				case "json":
+					if prettyJSON {
+						output, err = result.JsonPretty()
+					} else {
+						output, err = result.Json()
+					}
+				case "yaml":
+					output, err = result.Yaml()
+				case "csv":
+					output, err = result.Csv()
+				default:
+					log.Printf("Invalid format specified")
+				}
+				if err != nil {
+					log.Fatalf("Error formatting benchmark result: %v", err)
+				}
+				fmt.Println(output)
+				if runDir != "" {
+					path := filepath.Join(runDir, fmt.Sprintf("results_%s.%s", utils.SafeModelName(benchmark.ModelName), *format))
+					if err := os.WriteFile(path, []byte(output+"\n"), 0644); err != nil {
+						log.Printf("Error saving results: %v", err)
+					}
+				}
+			}
+		}
+
+		if len(comparisons) > 0 {
+			comparisonOut := tableOut
+			if comparisonOut == nil {
+				comparisonOut = os.Stderr
+			}
+			printModelComparison(comparisonOut, comparisons, *normalizeTo)
+			if path, err := saveModelComparison(runDir, comparisons, *normalizeTo); err != nil {
+				log.Printf("Error saving model comparison: %v", err)
+				failed = true
+			} else {
+				fmt.Fprintf(os.Stderr, "Comparison saved to: %s\n", path)
+			}
+		}
+
+		if *watch <= 0 || !waitForNextRun(*watch) {
+			break
+		}
+	}
+
+	stopProfiling()
+	if benchmark.Recorder != nil {
+		if closeErr := benchmark.Recorder.Close(); closeErr != nil {
+			log.Printf("Warning: failed to close trace file: %v", closeErr)
+		}
+	}
+	if runDir != "" {
+		if entries, err := os.ReadDir(runDir); err == nil {
+			fmt.Fprintf(os.Stderr, "Artifacts saved to %s:\n", runDir)
+			for _, entry := range entries {
+				fmt.Fprintf(os.Stderr, "  %s\n", filepath.Join(runDir, entry.Name()))
+			}
+		}
+	}
+	if gated {
+		os.Exit(3)
 	}
+	if failed {
+		os.Exit(1)
+	}
+}
 
-	// Get input tokens
-	if benchmark.UseRandomInput {
-		_, _, promptTokens, err := api.AskOpenAiRandomInput(client, benchmark.ModelName, *numWords/4, 4, benchmark.UseMaxCompletionTokens, nil)
+// saveResult writes the result in format to path.
+func saveResult(result BenchmarkResult, format string, path string) error {
+	switch format {
+	case "json":
+		return utils.SaveResultsToJSON(&result, path)
+	case "yaml":
+		return utils.SaveResultsToYAML(&result, path)
+	case "csv":
+		output, err := result.Csv()
 		if err != nil {
-			log.Fatalf("Error getting prompt tokens: %v", err)
+			return err
 		}
-		benchmark.InputTokens = promptTokens
-	} else {
-		_, _, promptTokens, err := api.AskOpenAi(client, benchmark.ModelName, *prompt, 4, benchmark.UseMaxCompletionTokens, nil)
+		return os.WriteFile(path, []byte(output+"\n"), 0644)
+	default:
+		return fmt.Errorf("invalid format '%s'", format)
+	}
+}
+
+// artifactPath places a relative path inside the run directory. Absolute and empty paths are kept.
+func artifactPath(runDir string, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(runDir, path)
+}
+
+// resolveAPIKey picks the API key with the precedence --api-key, --api-key-file, --api-key-env and finally OPENAI_API_KEY.
+func resolveAPIKey(flagKey string, keyFile string, keyEnv string) (string, error) {
+	if flagKey != "" {
+		return flagKey, nil
+	}
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
 		if err != nil {
-			log.Fatalf("Error getting prompt tokens: %v", err)
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	if keyEnv != "" {
+		if key := os.Getenv(keyEnv); key != "" {
+			return key, nil
 		}
-		benchmark.InputTokens = promptTokens
 	}
+	return os.Getenv("OPENAI_API_KEY"), nil
+}
+
+// buildTLSConfig combines all TLS options into one configuration. It returns nil if none are set,
+// in which case the default transport settings apply.
+func buildTLSConfig(insecureSkipVerify bool, certFile string, keyFile string, caFile string) (*tls.Config, error) {
+	if !insecureSkipVerify && certFile == "" && keyFile == "" && caFile == "" {
+		return nil, nil
+	}
+	config := &tls.Config{}
 
-	if *format == "" {
-		err := benchmark.runCli()
+	if insecureSkipVerify {
+		fmt.Fprintln(os.Stderr, "\n/!\\ WARNING: Skipping TLS certificate verification. This is insecure and should not be used in production. /!\\")
+		config.InsecureSkipVerify = true
+	}
+
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("--tls-cert and --tls-key must be used together")
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
 		if err != nil {
-			log.Fatalf("Error running benchmark: %v", err)
+			return nil, fmt.Errorf("loading client certificate: %w", err)
 		}
-	} else {
-		result, err := benchmark.run()
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
 		if err != nil {
-			log.Fatalf("Error running benchmark: %v", err)
+			return nil, fmt.Errorf("reading CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
 		}
+		config.RootCAs = pool
+	}
+
+	return config, nil
+}
 
-		var output string
-		switch *format {
-		case "json":
-			output, err = result.Json()
-		case "yaml":
-			output, err = result.Yaml()
+// startProfiling starts the profiles requested via --profile. The returned function stops the CPU
+// profile and writes the heap profile; it must be called once all concurrency levels are done.
+func startProfiling(specs []string) (func(), error) {
+	var cpuPath, memPath string
+	for _, spec := range specs {
+		kind, path, ok := strings.Cut(spec, "=")
+		if !ok || path == "" {
+			return nil, fmt.Errorf("invalid profile '%s', expected 'cpu=path' or 'mem=path'", spec)
+		}
+		switch kind {
+		case "cpu":
+			cpuPath = path
+		case "mem":
+			memPath = path
 		default:
-			log.Printf("Invalid format specified")
+			return nil, fmt.Errorf("unknown profile type '%s', expected 'cpu' or 'mem'", kind)
 		}
+	}
+
+	var cpuFile *os.File
+	if cpuPath != "" {
+		var err error
+		cpuFile, err = os.Create(cpuPath)
 		if err != nil {
-			log.Fatalf("Error formatting benchmark result: %v", err)
+			return nil, fmt.Errorf("error creating CPU profile: %w", err)
+		}
+		if err := pprof.StartCPUProfile(cpuFile); err != nil {
+			cpuFile.Close()
+			return nil, fmt.Errorf("error starting CPU profile: %w", err)
 		}
-		fmt.Println(output)
 	}
+
+	return func() {
+		if cpuFile != nil {
+			pprof.StopCPUProfile()
+			cpuFile.Close()
+			fmt.Fprintf(os.Stderr, "CPU profile saved to: %s\n", cpuPath)
+		}
+		if memPath != "" {
+			memFile, err := os.Create(memPath)
+			if err != nil {
+				log.Printf("Error creating memory profile: %v", err)
+				return
+			}
+			defer memFile.Close()
+			runtime.GC() // get up-to-date statistics
+			if err := pprof.WriteHeapProfile(memFile); err != nil {
+				log.Printf("Error writing memory profile: %v", err)
+				return
+			}
+			fmt.Fprintf(os.Stderr, "Memory profile saved to: %s\n", memPath)
+		}
+	}, nil
 }
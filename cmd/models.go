@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Yoosu-L/llmapibenchmark/internal/api"
+	"github.com/sashabaranov/go-openai"
+	"go.yaml.in/yaml/v4"
+)
+
+// modelSpec is a model to benchmark, with optional overrides of the command-line settings.
+type modelSpec struct {
+	Name      string `json:"model" yaml:"model"`
+	MaxTokens int    `json:"max-tokens,omitempty" yaml:"max-tokens,omitempty"`
+	Prompt    string `json:"prompt,omitempty" yaml:"prompt,omitempty"`
+}
+
+// loadModelsFile reads the models to benchmark. YAML and JSON files contain a list of modelSpec entries,
+// any other file lists one model per line, optionally followed by max-tokens=N. Lines starting with # are ignored.
+func loadModelsFile(path string) ([]modelSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var models []modelSpec
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+		if err := yaml.Unmarshal(data, &models); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+	default:
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for line := 1; scanner.Scan(); line++ {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+			spec := modelSpec{Name: fields[0]}
+			for _, field := range fields[1:] {
+				value, ok := strings.CutPrefix(field, "max-tokens=")
+				if !ok {
+					return nil, fmt.Errorf("%s:%d: unknown option '%s'", path, line, field)
+				}
+				if spec.MaxTokens, err = strconv.Atoi(value); err != nil || spec.MaxTokens <= 0 {
+					return nil, fmt.Errorf("%s:%d: invalid max-tokens '%s'", path, line, value)
+				}
+			}
+			models = append(models, spec)
+		}
+	}
+
+	for i, spec := range models {
+		if spec.Name == "" {
+			return nil, fmt.Errorf("%s: entry %d has no model name", path, i+1)
+		}
+	}
+	if len(models) == 0 {
+		return nil, fmt.Errorf("%s lists no models", path)
+	}
+	return models, nil
+}
+
+// validateModels checks that every model is offered by the server before any benchmark is started.
+func validateModels(client *openai.Client, models []modelSpec) error {
+	available, err := api.ListModelIDs(client)
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool, len(available))
+	for _, id := range available {
+		known[id] = true
+	}
+
+	var missing []string
+	for _, spec := range models {
+		if !known[spec.Name] {
+			missing = append(missing, spec.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("models not available: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// modelDiscoveryRetryDelay is the pause between model discovery attempts.
+const modelDiscoveryRetryDelay = 2 * time.Second
+
+// discoverModel returns the first model offered by the server. Services started alongside the
+// benchmark, e.g. in the same Kubernetes job, may not be ready yet, so discovery is retried
+// every modelDiscoveryRetryDelay until timeout has passed. Client errors such as a missing
+// /models endpoint are not retried.
+func discoverModel(client *openai.Client, timeout time.Duration) (string, error) {
+	attempts := 1 + int(timeout/modelDiscoveryRetryDelay)
+	for attempt := 1; ; attempt++ {
+		model, err := api.GetFirstAvailableModel(client)
+		if err == nil || attempt >= attempts {
+			return model, err
+		}
+		var discoveryErr *api.ErrModelDiscoveryFailed
+		if errors.As(err, &discoveryErr) && discoveryErr.StatusCode >= 400 && discoveryErr.StatusCode < 500 {
+			return "", err
+		}
+		time.Sleep(modelDiscoveryRetryDelay)
+		fmt.Fprintf(os.Stderr, "Waiting for API to become ready (attempt %d/%d)…\n", attempt+1, attempts)
+	}
+}
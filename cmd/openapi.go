@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"go.yaml.in/yaml/v4"
+)
+
+// openAPISpec returns a minimal OpenAPI 3.0 document describing the JSON output of the benchmark.
+// The schemas are derived from the result structs, properties keep their field order.
+func openAPISpec() (string, error) {
+	schemas := &yaml.Node{Kind: yaml.MappingNode}
+	seen := make(map[string]bool)
+	addSchema(schemas, seen, reflect.TypeOf(BenchmarkResult{}))
+
+	response := mappingNode(
+		"description", scalarNode("Benchmark result as printed by --format json"),
+		"content", mappingNode(
+			"application/json", mappingNode(
+				"schema", mappingNode("$ref", scalarNode("#/components/schemas/BenchmarkResult")),
+			),
+		),
+	)
+	doc := mappingNode(
+		"openapi", scalarNode("3.0.3"),
+		"info", mappingNode(
+			"title", scalarNode("llmapibenchmark results"),
+			"version", scalarNode("1.0.0"),
+		),
+		"paths", &yaml.Node{Kind: yaml.MappingNode, Style: yaml.FlowStyle},
+		"components", mappingNode(
+			"schemas", schemas,
+			"responses", mappingNode("BenchmarkResult", response),
+		),
+	)
+
+	data, err := yaml.Marshal(doc)
+	if err != nil {
+		return "", fmt.Errorf("error marshalling OpenAPI spec: %v", err)
+	}
+	return string(data), nil
+}
+
+// addSchema adds the object schema of struct type t and of all structs it references.
+func addSchema(schemas *yaml.Node, seen map[string]bool, t reflect.Type) {
+	if seen[t.Name()] {
+		return
+	}
+	seen[t.Name()] = true
+
+	properties := &yaml.Node{Kind: yaml.MappingNode}
+	required := &yaml.Node{Kind: yaml.SequenceNode}
+	var nested []reflect.Type
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		// Fields left out when empty are the optional ones
+		if !strings.Contains(options, "omitempty") {
+			required.Content = append(required.Content, scalarNode(name))
+		}
+		schema, refs := typeSchema(field.Type)
+		properties.Content = append(properties.Content, scalarNode(name), schema)
+		nested = append(nested, refs...)
+	}
+
+	schemas.Content = append(schemas.Content, scalarNode(t.Name()), mappingNode(
+		"type", scalarNode("object"),
+		"required", required,
+		"properties", properties,
+	))
+	for _, ref := range nested {
+		addSchema(schemas, seen, ref)
+	}
+}
+
+// typeSchema returns the schema of a field type and the struct types it refers to.
+func typeSchema(t reflect.Type) (*yaml.Node, []reflect.Type) {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	case reflect.Float32, reflect.Float64:
+		return mappingNode("type", scalarNode("number")), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return mappingNode("type", scalarNode("integer")), nil
+	case reflect.String:
+		return mappingNode("type", scalarNode("string")), nil
+	case reflect.Bool:
+		return mappingNode("type", scalarNode("boolean")), nil
+	case reflect.Slice, reflect.Array:
+		items, refs := typeSchema(t.Elem())
+		return mappingNode("type", scalarNode("array"), "items", items), refs
+	case reflect.Map:
+		values, refs := typeSchema(t.Elem())
+		return mappingNode("type", scalarNode("object"), "additionalProperties", values), refs
+	case reflect.Struct:
+		return mappingNode("$ref", scalarNode("#/components/schemas/"+t.Name())), []reflect.Type{t}
+	default:
+		return mappingNode(), nil
+	}
+}
+
+func scalarNode(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Value: value}
+}
+
+// mappingNode builds a mapping from alternating string keys and node values.
+func mappingNode(pairs ...interface{}) *yaml.Node {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		node.Content = append(node.Content, scalarNode(pairs[i].(string)), pairs[i+1].(*yaml.Node))
+	}
+	return node
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/Yoosu-L/llmapibenchmark/internal/config"
+	"github.com/Yoosu-L/llmapibenchmark/internal/utils"
+)
+
+// applyPlan sets the defaults of a --plan on the benchmark and returns its concurrency levels.
+// A plan with num-words but no prompt uses random input even if --prompt was given.
+func applyPlan(benchmark *Benchmark, plan *config.Plan) []int {
+	if plan.MaxTokens > 0 {
+		benchmark.MaxTokens = plan.MaxTokens
+	}
+	if plan.Repeats > 0 {
+		benchmark.Repeats = plan.Repeats
+	}
+	if plan.NumWords > 0 {
+		benchmark.NumWords = plan.NumWords
+		benchmark.Prompt = defaultPrompt
+	}
+	if plan.Prompt != "" {
+		benchmark.Prompt = plan.Prompt
+	}
+	benchmark.PlanLevels = plan.LevelOverrides()
+	return plan.ConcurrencyLevels()
+}
+
+// capPlanLevels re-keys the per-level overrides of a --plan by the levels that CapConcurrencyLevels
+// clamps them to. Of the levels clamped to the same value, the first one in the plan keeps its override.
+func capPlanLevels(overrides map[int]config.PlanLevel, concurrencyLevels []int, limit int) map[int]config.PlanLevel {
+	if overrides == nil {
+		return nil
+	}
+	capped := make(map[int]config.PlanLevel, len(overrides))
+	seen := make(map[int]bool, len(concurrencyLevels))
+	for _, level := range concurrencyLevels {
+		target := min(level, limit)
+		if seen[target] {
+			continue
+		}
+		seen[target] = true
+		if override, ok := overrides[level]; ok {
+			override.Concurrency = target
+			capped[target] = override
+		}
+	}
+	return capped
+}
+
+// capReplay clamps the levels of a trace to limit, a clamped level replays only its first limit requests.
+// A level the trace already has at limit is kept, otherwise the smallest clamped level takes its place.
+func capReplay(trace map[int][]utils.TraceEntry, limit int) (map[int][]utils.TraceEntry, bool) {
+	levels := slices.Sorted(maps.Keys(trace))
+	capped := make(map[int][]utils.TraceEntry, len(trace))
+	clamped := false
+	for _, concurrency := range levels {
+		entries := trace[concurrency]
+		if concurrency > limit {
+			clamped = true
+			concurrency, entries = limit, entries[:limit]
+		}
+		if _, ok := capped[concurrency]; !ok {
+			capped[concurrency] = entries
+		}
+	}
+	return capped, clamped
+}
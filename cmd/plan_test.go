@@ -0,0 +1,48 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Yoosu-L/llmapibenchmark/internal/config"
+	"github.com/Yoosu-L/llmapibenchmark/internal/utils"
+)
+
+func TestCapPlanLevels(t *testing.T) {
+	overrides := map[int]config.PlanLevel{
+		2048: {Concurrency: 2048, MaxTokens: 64},
+		8:    {Concurrency: 8, MaxTokens: 128},
+		4096: {Concurrency: 4096, MaxTokens: 256},
+	}
+	got := capPlanLevels(overrides, []int{2048, 8, 4096}, 1024)
+	want := map[int]config.PlanLevel{
+		1024: {Concurrency: 1024, MaxTokens: 64},
+		8:    {Concurrency: 8, MaxTokens: 128},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("capPlanLevels = %v, want %v", got, want)
+	}
+}
+
+func TestCapReplay(t *testing.T) {
+	entries := func(n int) []utils.TraceEntry {
+		trace := make([]utils.TraceEntry, n)
+		for i := range trace {
+			trace[i] = utils.TraceEntry{Concurrency: n, Index: i}
+		}
+		return trace
+	}
+
+	got, clamped := capReplay(map[int][]utils.TraceEntry{2: entries(2), 8: entries(8), 16: entries(16)}, 4)
+	if !clamped {
+		t.Error("clamped = false, want true")
+	}
+	if len(got) != 2 || len(got[2]) != 2 || len(got[4]) != 4 || got[4][0].Concurrency != 8 {
+		t.Errorf("capReplay kept levels %v, want 2 and the first 4 requests of 8", got)
+	}
+
+	got, clamped = capReplay(map[int][]utils.TraceEntry{4: entries(4), 8: entries(8)}, 4)
+	if !clamped || len(got) != 1 || got[4][0].Concurrency != 4 {
+		t.Errorf("capReplay = %v, %v, want the recorded level 4 only", got, clamped)
+	}
+}
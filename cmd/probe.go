@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Yoosu-L/llmapibenchmark/internal/api"
+	"github.com/sashabaranov/go-openai"
+)
+
+// probePrompt is the prompt of --probe, as short as possible so that the check measures the server
+// rather than prefill and generation.
+const probePrompt = "ping"
+
+// runProbe sends a single one-token chat completion and returns how long it took. The client times out
+// at slo, so a hanging server fails as fast as a slow one, and a response arriving later is an error too.
+func runProbe(config openai.ClientConfig, httpClient *http.Client, model string, slo time.Duration) (time.Duration, error) {
+	probeClient := *httpClient
+	probeClient.Timeout = slo
+	config.HTTPClient = &probeClient
+
+	start := time.Now()
+	_, _, _, err := api.AskOpenAi(openai.NewClientWithConfig(config), api.AskOptions{Model: model, Prompt: probePrompt, MaxTokens: 1})
+	elapsed := time.Since(start)
+	if err != nil {
+		return elapsed, err
+	}
+	if elapsed > slo {
+		return elapsed, fmt.Errorf("took %s, longer than the SLO of %s", elapsed.Round(time.Millisecond), slo)
+	}
+	return elapsed, nil
+}
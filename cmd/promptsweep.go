@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/Yoosu-L/llmapibenchmark/internal/api"
+	"github.com/Yoosu-L/llmapibenchmark/internal/utils"
+)
+
+// calibrationWords is the length of the prompt used to estimate the number of tokens per random word.
+const calibrationWords = 256
+
+// PromptLengthResult is the measurement of one prompt length of --prompt-length-sweep.
+type PromptLengthResult struct {
+	TargetTokens int               `json:"target_tokens" yaml:"target-tokens"`
+	InputTokens  int               `json:"input_tokens" yaml:"input-tokens"` // Reported by a probe request of the same length
+	NumWords     int               `json:"num_words" yaml:"num-words"`
+	Result       utils.SpeedResult `json:"result" yaml:"result"`
+}
+
+// parsePromptLengths parses a comma-separated list of prompt lengths in tokens. A k suffix multiplies by
+// 1024 like context window sizes do, 4k is 4096 tokens.
+func parsePromptLengths(spec string) ([]int, error) {
+	var lengths []int
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.ToLower(strings.TrimSpace(field))
+		multiplier := 1
+		if trimmed, ok := strings.CutSuffix(field, "k"); ok {
+			field, multiplier = trimmed, 1024
+		}
+		length, err := strconv.Atoi(field)
+		if err != nil || length <= 0 {
+			return nil, fmt.Errorf("invalid prompt length %q", field)
+		}
+		lengths = append(lengths, length*multiplier)
+	}
+	return lengths, nil
+}
+
+// runPromptLengthSweep measures the single concurrency level once per entry of PromptLengths.
+// Random prompts are sized with the tokens per word of a calibration probe, so every request of a
+// length has its own prompt and prefix caching does not hide the prefill cost.
+// A table of the results is rendered to out unless it is nil.
+func (benchmark *Benchmark) runPromptLengthSweep(out io.Writer) (BenchmarkResult, error) {
+	result := benchmark.newResult()
+	if len(benchmark.ConcurrencyLevels) != 1 {
+		return result, fmt.Errorf("the prompt length sweep runs at a single concurrency level, got %d", len(benchmark.ConcurrencyLevels))
+	}
+	concurrency := benchmark.ConcurrencyLevels[0]
+
+	latency, err := utils.MeasureLatency(benchmark.BaseURL, 5)
+	if err != nil {
+		return result, fmt.Errorf("error testing latency: %v", err)
+	}
+	result.Latency = latency
+	if err := benchmark.checkLatencyGate(latency); err != nil {
+		return result, err
+	}
+
+	sweep := *benchmark
+	sweep.UseRandomInput = false
+	sweep.Prompt = api.GenerateSeededPhrase(0, calibrationWords)
+	calibrationTokens, completionTokens, err := sweep.probe()
+	if err != nil {
+		return result, fmt.Errorf("calibrating prompt length: %v", err)
+	}
+	result.OverheadTokens += calibrationTokens + completionTokens
+	if calibrationTokens <= 0 {
+		return result, fmt.Errorf("calibrating prompt length: the server reported no prompt tokens")
+	}
+	tokensPerWord := float64(calibrationTokens) / calibrationWords
+
+	if out != nil {
+		fmt.Fprintf(out, "Prompt length sweep for %s at concurrency %d (%s tokens per word)\n\n", benchmark.ModelName, concurrency, utils.FormatFloat(tokensPerWord))
+		fmt.Fprintln(out, "| Target Tokens | Input Tokens | Gen. TP (tokens/s) | Prefill (tokens/s) | Avg TTFT (s) | P95 TTFT (s) | Success Rate |")
+		fmt.Fprintln(out, "|---------------|--------------|--------------------|--------------------|--------------|--------------|--------------|")
+	}
+
+	for _, target := range benchmark.PromptLengths {
+		numWords := max(1, int(math.Round(float64(target)/tokensPerWord)))
+
+		sweep.UseRandomInput = false
+		sweep.Prompt = api.GenerateSeededPhrase(int64(target), numWords)
+		inputTokens, completionTokens, err := sweep.probe()
+		if err != nil {
+			return result, fmt.Errorf("prompt length %d: %v", target, err)
+		}
+		result.OverheadTokens += inputTokens + completionTokens
+
+		sweep.UseRandomInput = true
+		sweep.NumWords = numWords
+		sweep.InputTokens = inputTokens
+		// Every length needs its own room in the context window
+		sweep.MaxTokens = benchmark.MaxTokens
+		sweep.capMaxTokens()
+		measurement, err := sweep.measureLevel(latency, concurrency, false)
+		if err != nil {
+			return result, fmt.Errorf("prompt length %d: %w", target, err)
+		}
+		result.PromptLengthResults = append(result.PromptLengthResults, PromptLengthResult{
+			TargetTokens: target,
+			InputTokens:  inputTokens,
+			NumWords:     numWords,
+			Result:       measurement,
+		})
+
+		if out != nil {
+			fmt.Fprintf(out, "| %13d | %12d | %18s | %18s | %12s | %12s | %11s%% |\n",
+				target,
+				inputTokens,
+				utils.FormatFloat(measurement.GenerationSpeed),
+				utils.FormatFloat(measurement.PrefillSpeed),
+				utils.FormatFloat(measurement.AvgTtft),
+				utils.FormatFloat(measurement.P95Ttft),
+				utils.FormatFloat(measurement.SuccessRate*100),
+			)
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// envPlaceholder matches ${NAME} placeholders in a request file.
+var envPlaceholder = regexp.MustCompile(`\$\{(\w+)\}`)
+
+// loadRequestFile reads a complete chat completion request body. {api_key} and ${NAME} placeholders
+// are replaced by the API key and environment variables, streaming with usage is forced and the model
+// is taken from model if the file names none. It returns the body to send and the model it names.
+func loadRequestFile(path string, apiKey string, model string) ([]byte, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Values are escaped so that placeholders within JSON strings keep the document valid
+	text := strings.ReplaceAll(string(data), "{api_key}", jsonEscape(apiKey))
+	var missing []string
+	text = envPlaceholder.ReplaceAllStringFunc(text, func(placeholder string) string {
+		name := envPlaceholder.FindStringSubmatch(placeholder)[1]
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return jsonEscape(value)
+	})
+	if len(missing) > 0 {
+		return nil, "", fmt.Errorf("%s: environment variables not set: %s", path, strings.Join(missing, ", "))
+	}
+
+	var request map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(text), &request); err != nil {
+		return nil, "", fmt.Errorf("%s is not a JSON object: %w", path, err)
+	}
+
+	if raw, ok := request["model"]; ok {
+		var named string
+		if err := json.Unmarshal(raw, &named); err != nil || named == "" {
+			return nil, "", fmt.Errorf("%s: model must be a non-empty string", path)
+		}
+		if model != "" && model != named {
+			return nil, "", fmt.Errorf("%s names model '%s', but --model is '%s'", path, named, model)
+		}
+		model = named
+	} else if model == "" {
+		return nil, "", fmt.Errorf("%s names no model and --model is not set", path)
+	}
+
+	// The metrics are taken from the stream, TTFT and usage need it
+	request["model"], _ = json.Marshal(model)
+	request["stream"] = json.RawMessage("true")
+	if _, ok := request["stream_options"]; !ok {
+		request["stream_options"] = json.RawMessage(`{"include_usage":true}`)
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, "", err
+	}
+	return body, model, nil
+}
+
+// jsonEscape returns s escaped for use inside a JSON string.
+func jsonEscape(s string) string {
+	quoted, _ := json.Marshal(s)
+	return string(quoted[1 : len(quoted)-1])
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+)
+
+// strictExpectations are the checks enabled by --strict.
+type strictExpectations struct {
+	Model      string  // Expected model name, empty to accept any
+	MaxLatency float64 // Maximum network latency in milliseconds, 0 for no bound
+}
+
+// strictViolations returns a description of every check of --strict the result fails.
+func strictViolations(result BenchmarkResult, expect strictExpectations) []string {
+	var violations []string
+	if expect.Model != "" && result.ModelName != expect.Model {
+		violations = append(violations, fmt.Sprintf("model: benchmarked '%s', expected '%s'", result.ModelName, expect.Model))
+	}
+	if expect.MaxLatency > 0 && result.Latency > expect.MaxLatency {
+		violations = append(violations, fmt.Sprintf("latency: %.2f ms exceeds the bound of %.2f ms", result.Latency, expect.MaxLatency))
+	}
+	for _, measurement := range result.Results {
+		if measurement.FailedRequests > 0 {
+			violations = append(violations, fmt.Sprintf("concurrency %d: %d request(s) failed", measurement.Concurrency, measurement.FailedRequests))
+		}
+		if measurement.TruncatedResponses > 0 {
+			violations = append(violations, fmt.Sprintf("concurrency %d: %d response(s) truncated at max_tokens", measurement.Concurrency, measurement.TruncatedResponses))
+		}
+		if measurement.MissingUsageResponses > 0 {
+			violations = append(violations, fmt.Sprintf("concurrency %d: %d response(s) without usage", measurement.Concurrency, measurement.MissingUsageResponses))
+		}
+		if measurement.UnterminatedStreams > 0 {
+			violations = append(violations, fmt.Sprintf("concurrency %d: %d stream(s) ended without a finish_reason", measurement.Concurrency, measurement.UnterminatedStreams))
+		}
+	}
+	return violations
+}
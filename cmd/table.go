@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Yoosu-L/llmapibenchmark/internal/utils"
+)
+
+// tableColumn is a column of the live results table.
+type tableColumn struct {
+	header string
+	unit   string // Appended to the header unless short headers are requested
+	width  int    // Minimum width of the values
+	drop   int    // On narrow terminals columns with the highest rank are omitted first, 0 is never omitted
+	value  func(utils.SpeedResult) string
+	color  func(utils.SpeedResult, string) string // Optional, applied after padding
+}
+
+var tableColumns = []tableColumn{
+	{header: "C", width: 2, value: concurrencyLabel},
+	{header: "Gen Speed", unit: "tok/s", width: 9, value: func(m utils.SpeedResult) string { return utils.FormatFloat(m.GenerationSpeed) }},
+	{header: "Prompt TP", unit: "tok/s", width: 9, drop: 1, value: func(m utils.SpeedResult) string { return utils.FormatFloat(m.PromptThroughput) }},
+	{header: "Total TP", unit: "tok/s", width: 8, value: func(m utils.SpeedResult) string { return utils.FormatFloat(m.TotalThroughput) }},
+	{header: "Min TTFT", unit: "s", width: 8, drop: 3, value: func(m utils.SpeedResult) string { return utils.FormatFloat(m.MinTtft) }},
+	{header: "Avg TTFT", unit: "s", width: 8, value: func(m utils.SpeedResult) string { return utils.FormatFloat(m.AvgTtft) }},
+	{header: "Med TTFT", unit: "s", width: 8, drop: 5, value: func(m utils.SpeedResult) string { return utils.FormatFloat(m.MedianTtft) }},
+	{header: "P95 TTFT", unit: "s", width: 8, value: func(m utils.SpeedResult) string { return utils.FormatFloat(m.P95Ttft) }},
+	{header: "P99 TTFT", unit: "s", width: 8, drop: 2, value: func(m utils.SpeedResult) string { return utils.FormatFloat(m.P99Ttft) }},
+	{header: "StdDev", unit: "s", width: 6, drop: 4, value: func(m utils.SpeedResult) string { return utils.FormatFloat(m.StdDevTtft) }},
+	{
+		header: "Success",
+		width:  7,
+		value:  func(m utils.SpeedResult) string { return utils.FormatFloat(m.SuccessRate*100) + "%" },
+		color: func(m utils.SpeedResult, s string) string {
+			if m.SuccessRate < 1 {
+				return utils.Red(s)
+			}
+			return utils.Green(s)
+		},
+	},
+	{header: "Reqs", width: 4, value: func(m utils.SpeedResult) string { return fmt.Sprintf("%d", m.SuccessfulRequests) }},
+	{header: "Duration", unit: "s", width: 8, value: func(m utils.SpeedResult) string { return utils.FormatFloat(m.Duration) }},
+}
+
+// normalizedColumn is inserted after the generation speed with --show-normalized, it is omitted first on
+// narrow terminals.
+var normalizedColumn = tableColumn{header: "Norm Speed", unit: "tok/s", width: 10, drop: 6, value: func(m utils.SpeedResult) string { return utils.FormatFloat(m.NormalizedGenerationSpeed) }}
+
+func (column tableColumn) padded() int {
+	return max(column.width, len(column.header))
+}
+
+// tableWidth returns the rendered width of a table with these columns.
+func tableWidth(columns []tableColumn) int {
+	width := 1
+	for _, column := range columns {
+		width += column.padded() + 3
+	}
+	return width
+}
+
+// selectColumns omits the least important columns until the table fits into width.
+// A width of 0 or less keeps all columns. Headers include their unit unless short is set.
+// With normalized the NormalizedGenerationSpeed column is added.
+func selectColumns(width int, short bool, normalized bool) []tableColumn {
+	columns := append([]tableColumn(nil), tableColumns...)
+	if normalized {
+		columns = slices.Insert(columns, 2, normalizedColumn)
+	}
+	for i, column := range columns {
+		if !short && column.unit != "" {
+			columns[i].header = fmt.Sprintf("%s (%s)", column.header, column.unit)
+		}
+	}
+	for width > 0 && tableWidth(columns) > width {
+		drop := -1
+		for i, column := range columns {
+			if column.drop > 0 && (drop < 0 || column.drop > columns[drop].drop) {
+				drop = i
+			}
+		}
+		if drop < 0 {
+			break
+		}
+		columns = append(columns[:drop], columns[drop+1:]...)
+	}
+	return columns
+}
+
+func tableHeader(columns []tableColumn) string {
+	cells := make([]string, len(columns))
+	for i, column := range columns {
+		cells[i] = fmt.Sprintf("%-*s", column.padded(), column.header)
+	}
+	return "| " + strings.Join(cells, " | ") + " |"
+}
+
+func tableSeparator(columns []tableColumn) string {
+	cells := make([]string, len(columns))
+	for i, column := range columns {
+		cells[i] = strings.Repeat("-", column.padded()+2)
+	}
+	return "|" + strings.Join(cells, "|") + "|"
+}
+
+func tableRow(columns []tableColumn, measurement utils.SpeedResult) string {
+	cells := make([]string, len(columns))
+	for i, column := range columns {
+		cells[i] = fmt.Sprintf("%*s", column.padded(), column.value(measurement))
+		if column.color != nil {
+			cells[i] = column.color(measurement, cells[i])
+		}
+	}
+	return "| " + strings.Join(cells, " | ") + " |"
+}
+
+// concurrencyLabel labels the summary row, which has no concurrency level, as ALL.
+func concurrencyLabel(m utils.SpeedResult) string {
+	if m.Concurrency == 0 {
+		return "ALL"
+	}
+	return fmt.Sprintf("%d", m.Concurrency)
+}
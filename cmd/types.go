@@ -1,6 +1,15 @@
 package main
 
-import "github.com/Yoosu-L/llmapibenchmark/internal/utils"
+import (
+	"crypto/tls"
+	"net/http"
+	"time"
+
+	"github.com/Yoosu-L/llmapibenchmark/internal/api"
+	"github.com/Yoosu-L/llmapibenchmark/internal/config"
+	"github.com/Yoosu-L/llmapibenchmark/internal/utils"
+	"github.com/sashabaranov/go-openai"
+)
 
 type Benchmark struct {
 	BaseURL                string
@@ -9,18 +18,112 @@ type Benchmark struct {
 	ModelName              string
 	Prompt                 string
 	InputTokens            int
+	OverheadTokens         int // Consumed by the probe request that determines InputTokens
 	MaxTokens              int
+	ContextWindow          int // Of the model, MaxTokens is capped to fit; 0 if unknown or --no-auto-cap
 	ConcurrencyLevels      []int
+	DescendingConcurrency  bool                     // ConcurrencyLevels run from the highest to the lowest
+	PlanLevels             map[int]config.PlanLevel // Per-level overrides of a --plan, keyed by concurrency
 	UseRandomInput         bool
 	NumWords               int
 	Headers                map[string]string
 	UseMaxCompletionTokens bool
+	NumMessages            int
+	Repeats                int
+	BurstSize              int
+	BurstDelay             time.Duration
+
+	InjectLatency            time.Duration
+	InjectLatencyProbability float64
+	MeasureColdTtft          bool
+	MaxRetries               int
+	Timeout                  time.Duration
+	TimeoutScale             float64
+	IncludeRawData           bool
+	UniquePrompts            bool
+	InputSeed                int64 // Seed of the random input, 0 for a different input every run
+	ReadTokens               int
+	RegionHeader             string
+	DetectCache              bool
+	InterleaveModel          string // Second model alternated with ModelName within every level
+	SoftDeadline             time.Duration
+	WarnOutputVariance       float64
+	Tokenizer                api.Tokenizer
+	RequestBody              []byte       // Sent instead of the request built from the flags, see --request-file
+	HTTPClient               *http.Client // Unwrapped transport built from the flags, Run adds headers and the request body
+	promptsSent              *int64       // Requests sent so far with UniquePrompts, each one gets the next seed. Shared by all copies, so models and watch runs never repeat a seed
+	SampleInterval           time.Duration
+	ProgressMode             string
+	ProgressStyle            string
+	PrewarmConnections       bool
+	DisableKeepAlives        bool
+	ValidateStreams          bool
+	CookieJar                http.CookieJar
+	TLSConfig                *tls.Config
+	Tools                    *api.ToolConfig
+	API                      string
+	ResponsesClient          *api.ResponsesClient
+	TritonClient             *api.TritonClient
+	SplitBaseURL             string  // Second endpoint for A/B load splitting
+	SplitWeight              float64 // Share of the requests sent to SplitBaseURL
+	MaxInitialLatency        float64 // Milliseconds, aborts the benchmark if the initial latency is higher
+	PromptLengths            []int   // Prompt lengths in tokens to sweep at a fixed concurrency instead of the concurrency sweep
+
+	TableWidth     int    // Width the CLI table has to fit into, 0 for the full table
+	NoHighlight    bool   // Plain Markdown rows without bold/strikethrough
+	ShortHeaders   bool   // Table headers without units
+	ShowNormalized bool   // Add the prompt-length normalized generation speed to the CLI table
+	NoChart        bool   // No generation speed chart in the Markdown file
+	OutputDir      string // Directory for all artifacts of the run
+	AppendResults  bool   // Add a section to the Markdown file instead of overwriting it
+	WideTable      bool   // Also save a Markdown table with every SpeedResult field
+
+	ReportTitle       string // Replaces the banner title and becomes the H1 of the Markdown file
+	ReportDescription string // Paragraph before the table
+
+	WarnOnVariance float64 // TTFT coefficient of variation above which a level is flagged, 0 to disable
+
+	// Workload traces
+	Recorder *utils.TraceRecorder
+	Replay   map[int][]utils.TraceEntry
+
+	// Checkpointing, completed levels are skipped
+	CheckpointFile string
+	Checkpoint     map[int]utils.SpeedResult
+
+	// Batch mode
+	Client            *openai.Client
+	BatchPollInterval time.Duration
+	PricePrompt       float64 // Per 1M prompt tokens of regular requests, 0 if unknown
+	PriceCompletion   float64 // Per 1M completion tokens of regular requests, 0 if unknown
+	BatchDiscount     float64 // Share taken off the prices for batch jobs
 }
 
 type BenchmarkResult struct {
-	ModelName   string              `json:"model_name" yaml:"model-name"`
-	InputTokens int                 `json:"input_tokens" yaml:"input-tokens"`
-	MaxTokens   int                 `json:"output_tokens" yaml:"output-tokens"` // Historically been called Output Tokens
-	Latency     float64             `json:"latency" yaml:"latency"`
-	Results     []utils.SpeedResult `json:"results" yaml:"results"`
+	ModelName      string              `json:"model_name" yaml:"model-name"`
+	InputTokens    int                 `json:"input_tokens" yaml:"input-tokens"`
+	MaxTokens      int                 `json:"output_tokens" yaml:"output-tokens"` // Historically been called Output Tokens
+	NumMessages    int                 `json:"num_messages" yaml:"num-messages"`
+	Latency        float64             `json:"latency" yaml:"latency"`
+	OverheadTokens int                 `json:"overhead_tokens" yaml:"overhead-tokens"` // Consumed by the probe request, not part of any result totals
+	Results        []utils.SpeedResult `json:"results" yaml:"results"`
+
+	BatchResults        []api.BatchResult    `json:"batch_results,omitempty" yaml:"batch-results,omitempty"`
+	PromptLengthResults []PromptLengthResult `json:"prompt_length_results,omitempty" yaml:"prompt-length-results,omitempty"`
+}
+
+// Normalize returns a copy of the result in which every GenerationSpeed is scaled to a model that
+// always generates targetAvgCompletionTokens tokens, which makes models with different response
+// lengths comparable. Levels without completion tokens are left unchanged.
+func (benchmark BenchmarkResult) Normalize(targetAvgCompletionTokens float64) BenchmarkResult {
+	normalized := benchmark
+	normalized.Results = make([]utils.SpeedResult, len(benchmark.Results))
+	for i, result := range benchmark.Results {
+		if result.AvgCompletionTokens > 0 {
+			result.GenerationSpeed = utils.RoundToPrecision(result.GenerationSpeed * targetAvgCompletionTokens / result.AvgCompletionTokens)
+			result.NormalizedGenerationSpeed = utils.NormalizedGenerationSpeed(result.GenerationSpeed, result.AvgPromptTokens)
+		}
+		normalized.Results[i] = result
+	}
+	return normalized
 }
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Yoosu-L/llmapibenchmark/internal/utils"
+)
+
+// waitForNextRun waits for the --watch interval and reports whether the next run should start.
+// Ctrl-C while waiting ends the watch gracefully; during a run it keeps its default behavior.
+func waitForNextRun(interval time.Duration) bool {
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupted)
+
+	fmt.Fprintf(os.Stderr, "Next run at %s, press Ctrl-C to stop.\n", time.Now().Add(interval).Format("15:04:05"))
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-interrupted:
+		fmt.Fprintln(os.Stderr, "Stopped watching.")
+		return false
+	}
+}
+
+// printRunDelta prints the change of the summary of all levels since the previous run.
+func printRunDelta(w io.Writer, model string, previous utils.SpeedResult, current utils.SpeedResult) {
+	line := fmt.Sprintf("Change from previous run (%s): generation speed %+.2f tokens/s", model, current.GenerationSpeed-previous.GenerationSpeed)
+	if previous.GenerationSpeed > 0 {
+		line += fmt.Sprintf(" (%+.1f%%)", (current.GenerationSpeed-previous.GenerationSpeed)/previous.GenerationSpeed*100)
+	}
+	line += fmt.Sprintf(", avg TTFT %+.2f s, P95 TTFT %+.2f s, success rate %+.2f%%",
+		current.AvgTtft-previous.AvgTtft, current.P95Ttft-previous.P95Ttft, (current.SuccessRate-previous.SuccessRate)*100)
+	fmt.Fprintln(w, line)
+}
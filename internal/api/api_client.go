@@ -2,53 +2,269 @@ package api
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptrace"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/sashabaranov/go-openai"
 	"github.com/schollz/progressbar/v3"
 )
 
-// AskOpenAi sends a prompt to the OpenAI API, processes the response stream and returns stats on it.
-func AskOpenAi(client *openai.Client, model string, prompt string, maxTokens int, useMaxCompletionTokens bool, bar *progressbar.ProgressBar) (float64, int, int, error) {
+// DebugLogger receives debug messages such as retry attempts. Debug output is disabled when nil.
+var DebugLogger *log.Logger
+
+// VerboseWriter receives the request bodies of requests with RequestStats.LogRequestBody set. Nothing is written when nil.
+var VerboseWriter io.Writer
+
+// maxLoggedBodyLength is the number of characters of a request body written to VerboseWriter.
+const maxLoggedBodyLength = 500
+
+// RequestStats collects per-request details that are not part of AskOpenAi's return values.
+type RequestStats struct {
+	Index                  int // Request index, used in debug messages
+	ConnectionResetRetries int
+	FinishReason           string        // Empty if the stream ended without a finish_reason
+	ToolCall               bool          // The model answered with tool calls
+	Prompt                 string        // The prompt that was sent, used when recording traces
+	ResponseHash           uint64        // FNV-1a hash of the full streamed response, used to detect duplicates
+	StreamedTokens         *atomic.Int64 // Shared counter of the estimated tokens streamed so far, may be nil
+	UsageMissing           bool          // The server sent no usage, token counts are estimates
+	ReadTokens             int           // Cancel the request once this many tokens were streamed, 0 to read the whole response
+	PartialRead            bool          // The request was cancelled after ReadTokens tokens
+	ConnectionReused       bool          // The last attempt was sent on a pooled keep-alive connection
+	Tokenizer              Tokenizer     // Recounts the completion into NormalizedTokens if set
+	NormalizedTokens       int           // Completion tokens of the response text as counted by Tokenizer
+	SoftDeadline           time.Duration // Cancel the request this long after it was first sent, keeping what was streamed, 0 for none
+	Abandoned              bool          // The request was cancelled at SoftDeadline
+	ResponseHeader         http.Header   // Header of the streamed response, nil if the request failed
+	LogRequestBody         bool          // Write the request body to VerboseWriter before the first attempt
+	BarTokens              int           // Tokens the progress bar was advanced by, over all attempts
+
+	deadlinePassed atomic.Bool
+}
+
+// AskOptions are the parameters of a single benchmark request, shared by all API clients.
+type AskOptions struct {
+	Model                  string
+	Prompt                 string
+	MaxTokens              int         // 0 sends no limit and lets the model decide when to stop
+	UseMaxCompletionTokens bool        // Send max_completion_tokens instead of max_tokens
+	NumMessages            int         // Split the prompt across this many alternating user/assistant messages
+	MaxRetries             int         // Retries of requests failing with a connection reset before any content arrived
+	Tools                  *ToolConfig // Tools offered to the model, nil for none
+	Temperature            *float32    // Sampling temperature, nil for the default of 1
+	Seed                   *int        // Sampling seed, nil to leave it to the server. Chat completions only
+	Stop                   []string    // Stop sequences. Chat completions only
+
+	Stats *RequestStats            // Receives per-request details, may be nil
+	Bar   *progressbar.ProgressBar // Advanced by the streamed tokens, may be nil
+}
+
+// temperature returns the temperature to send, benchmarks sample at 1 unless told otherwise.
+func (opts AskOptions) temperature() float32 {
+	if opts.Temperature == nil {
+		return 1
+	}
+	return *opts.Temperature
+}
+
+// stats returns opts.Stats, or a throwaway RequestStats if it is nil, with the prompt recorded.
+func (opts AskOptions) stats() *RequestStats {
+	stats := opts.Stats
+	if stats == nil {
+		stats = &RequestStats{}
+	}
+	stats.Prompt = opts.Prompt
+	return stats
+}
+
+// AskOpenAi sends a prompt to the OpenAI API, processes the response stream and returns stats on it:
+// the TTFT in seconds, the completion and the prompt tokens.
+func AskOpenAi(client *openai.Client, opts AskOptions) (float64, int, int, error) {
+	stats := opts.stats()
+	req := chatCompletionRequest(opts)
+	// Logged before the clock starts, writing the body must not add to the TTFT
+	if stats.LogRequestBody {
+		stats.LogRequestBody = false
+		logRequestBody(req)
+	}
 	start := time.Now()
 
-	var (
-		timeToFirstToken   float64
-		firstTokenSeen     bool
-		lastUsage          *openai.Usage
-		accumulatedContent string // Accumulate all content to count tokens more accurately
-		estimatedTokens    int    // Real-time token estimation
-	)
+	return withRetries(opts.MaxRetries, stats, func() (float64, int, int, bool, error) {
+		return askOpenAiOnce(client, req, opts, start, stats)
+	})
+}
 
-	req := openai.ChatCompletionRequest{
-		Model: model,
-		Messages: []openai.ChatCompletionMessage{
-			{
-				Role:    openai.ChatMessageRoleUser,
-				Content: prompt,
-			},
+// AskOpenAiPositional is AskOpenAi with the parameters as arguments.
+//
+// Deprecated: use AskOpenAi, AskOptions also covers temperature, seed and stop sequences.
+func AskOpenAiPositional(client *openai.Client, model string, prompt string, maxTokens int, useMaxCompletionTokens bool, numMessages int, maxRetries int, tools *ToolConfig, stats *RequestStats, bar *progressbar.ProgressBar) (float64, int, int, error) {
+	return AskOpenAi(client, AskOptions{
+		Model:                  model,
+		Prompt:                 prompt,
+		MaxTokens:              maxTokens,
+		UseMaxCompletionTokens: useMaxCompletionTokens,
+		NumMessages:            numMessages,
+		MaxRetries:             maxRetries,
+		Tools:                  tools,
+		Stats:                  stats,
+		Bar:                    bar,
+	})
+}
+
+// withRetries calls once until it succeeds, fails with anything but a connection reset, streamed
+// content before failing, or maxRetries retries have been made.
+func withRetries(maxRetries int, stats *RequestStats, once func() (float64, int, int, bool, error)) (float64, int, int, error) {
+	for attempt := 0; ; attempt++ {
+		ttft, completionTokens, promptTokens, received, err := once()
+		if err == nil || received || attempt >= maxRetries || !isConnectionReset(err) {
+			return ttft, completionTokens, promptTokens, err
+		}
+
+		stats.ConnectionResetRetries++
+		if DebugLogger != nil {
+			DebugLogger.Printf("request %d: connection reset, retrying (attempt %d/%d): %v", stats.Index, attempt+1, maxRetries, err)
+		}
+		time.Sleep(time.Duration(50+rand.Intn(151)) * time.Millisecond)
+	}
+}
+
+// isConnectionReset reports whether err is a transient connection reset worth retrying.
+func isConnectionReset(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// readLimitReached reports whether streamedTokens reached ReadTokens and marks the request as partially read.
+func (stats *RequestStats) readLimitReached(streamedTokens int) bool {
+	if stats.ReadTokens <= 0 || streamedTokens < stats.ReadTokens {
+		return false
+	}
+	stats.PartialRead = true
+	return true
+}
+
+// startSoftDeadline cancels the request once SoftDeadline has passed since start. The returned
+// function stops the timer.
+func (stats *RequestStats) startSoftDeadline(start time.Time, cancel context.CancelFunc) func() bool {
+	if stats.SoftDeadline <= 0 {
+		return func() bool { return false }
+	}
+	timer := time.AfterFunc(stats.SoftDeadline-time.Since(start), func() {
+		stats.deadlinePassed.Store(true)
+		cancel()
+	})
+	return timer.Stop
+}
+
+// abandon reports whether the request failed because SoftDeadline passed and marks it as abandoned.
+func (stats *RequestStats) abandon() bool {
+	if !stats.deadlinePassed.Load() {
+		return false
+	}
+	stats.Abandoned = true
+	return true
+}
+
+// traceConnection returns ctx with a trace recording in ConnectionReused whether the request got a pooled connection.
+func (stats *RequestStats) traceConnection(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			stats.ConnectionReused = info.Reused
 		},
-		Temperature: 1,
+	})
+}
+
+// IsTimeout reports whether a request failed because its timeout expired, before or while streaming.
+func IsTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// chatCompletionRequest returns the streaming chat completion request of opts.
+func chatCompletionRequest(opts AskOptions) openai.ChatCompletionRequest {
+	temperature := opts.temperature()
+	if temperature == 0 {
+		// go-openai omits a zero temperature, the smallest float32 is its way of sending 0
+		temperature = math.SmallestNonzeroFloat32
+	}
+	req := openai.ChatCompletionRequest{
+		Model:       opts.Model,
+		Messages:    buildMessages(opts.Prompt, opts.NumMessages),
+		Temperature: temperature,
+		Seed:        opts.Seed,
+		Stop:        opts.Stop,
 		Stream:      true,
 		StreamOptions: &openai.StreamOptions{
 			IncludeUsage: true,
 		},
 	}
+	if opts.Tools != nil {
+		req.Tools = opts.Tools.Tools
+		req.ToolChoice = opts.Tools.ToolChoice
+	}
 	// Use either MaxTokens or MaxCompletionTokens based on the flag
-	if useMaxCompletionTokens {
-		req.MaxCompletionTokens = maxTokens
+	if opts.UseMaxCompletionTokens {
+		req.MaxCompletionTokens = opts.MaxTokens
 	} else {
-		req.MaxTokens = maxTokens
+		req.MaxTokens = opts.MaxTokens
+	}
+	return req
+}
+
+// logRequestBody writes the JSON body of req to VerboseWriter, truncated to maxLoggedBodyLength characters.
+func logRequestBody(req openai.ChatCompletionRequest) {
+	if VerboseWriter == nil {
+		return
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		fmt.Fprintf(VerboseWriter, "Request body: %v\n", err)
+		return
+	}
+	text := string(body)
+	if runes := []rune(text); len(runes) > maxLoggedBodyLength {
+		text = fmt.Sprintf("%s... (%d bytes)", string(runes[:maxLoggedBodyLength]), len(body))
 	}
-	stream, err := client.CreateChatCompletionStream(context.Background(), req)
+	fmt.Fprintf(VerboseWriter, "Request body: %s\n", text)
+}
+
+// askOpenAiOnce performs a single streaming request. TTFT is measured from start so that retries
+// are included in it. received reports whether any content was streamed before an error.
+func askOpenAiOnce(client *openai.Client, req openai.ChatCompletionRequest, opts AskOptions, start time.Time, stats *RequestStats) (float64, int, int, bool, error) {
+	prompt, bar := opts.Prompt, opts.Bar
+	var (
+		timeToFirstToken   float64
+		firstTokenSeen     bool
+		lastUsage          *openai.Usage
+		accumulatedContent string // Accumulate all content to count tokens more accurately
+		estimatedTokens    int    // Real-time token estimation
+	)
+
+	var header http.Header
+	ctx, cancel := context.WithCancel(withResponseHeader(stats.traceConnection(context.Background()), &header))
+	defer cancel()
+	defer stats.startSoftDeadline(start, cancel)()
+	stream, err := client.CreateChatCompletionStream(ctx, req)
 	if err != nil {
-		return 0, 0, 0, fmt.Errorf("OpenAI API request failed: %w", err)
+		if stats.abandon() {
+			return 0, 0, 0, false, nil
+		}
+		return 0, 0, 0, false, fmt.Errorf("OpenAI API request failed: %w", toRequestError(err, header))
 	}
 	defer stream.Close()
+	stats.ResponseHeader = header
 
 	for {
 		resp, err := stream.Recv()
@@ -56,31 +272,45 @@ func AskOpenAi(client *openai.Client, model string, prompt string, maxTokens int
 			break
 		}
 		if err != nil {
-			return 0, 0, 0, fmt.Errorf("stream error: %w", err)
+			if stats.abandon() {
+				break
+			}
+			return 0, 0, 0, accumulatedContent != "", fmt.Errorf("stream error: %w", toRequestError(err, nil))
 		}
 
-		if !firstTokenSeen && len(resp.Choices) > 0 {
-			content := resp.Choices[0].Delta.Content
-			if strings.TrimSpace(content) != "" {
-				timeToFirstToken = time.Since(start).Seconds()
-				firstTokenSeen = true
-			}
+		var content string
+		if len(resp.Choices) > 0 {
+			content = deltaContent(resp.Choices[0].Delta, stats)
+		}
+
+		if !firstTokenSeen && strings.TrimSpace(content) != "" {
+			timeToFirstToken = time.Since(start).Seconds()
+			firstTokenSeen = true
 		}
 
 		// Process each chunk, accumulating to response content
-		if len(resp.Choices) > 0 {
-			content := resp.Choices[0].Delta.Content
-			if content != "" {
-				accumulatedContent += content
+		if content != "" {
+			accumulatedContent += content
 
-				// Estimate number of tokens in current chunk
-				newTokens := estimateTokens(content)
-				estimatedTokens += newTokens
+			// Estimate number of tokens in current chunk
+			newTokens := estimateTokens(content)
+			estimatedTokens += newTokens
 
-				if bar != nil {
-					bar.Add(newTokens)
-				}
+			if bar != nil {
+				bar.Add(newTokens)
+				stats.BarTokens += newTokens
 			}
+			if stats.StreamedTokens != nil {
+				stats.StreamedTokens.Add(int64(newTokens))
+			}
+		}
+		if stats.readLimitReached(estimatedTokens) {
+			cancel()
+			break
+		}
+
+		if len(resp.Choices) > 0 && resp.Choices[0].FinishReason != "" {
+			stats.FinishReason = string(resp.Choices[0].FinishReason)
 		}
 
 		if resp.Usage != nil {
@@ -98,19 +328,83 @@ func AskOpenAi(client *openai.Client, model string, prompt string, maxTokens int
 			diff := completionTokens - estimatedTokens
 			if diff != 0 { // Could be positive or negative
 				bar.Add(diff)
+				stats.BarTokens += diff
 			}
 		}
+	} else if stats.PartialRead || stats.Abandoned {
+		// Cancelled before the usage was sent, the prompt is estimated as well
+		completionTokens = estimatedTokens
+		promptTokens = estimateTokens(prompt)
 	} else {
 		// If no usage info, use our estimated tokens as completion tokens
 		completionTokens = estimatedTokens
+		stats.UsageMissing = true
+	}
+	stats.ResponseHash = hashResponse(accumulatedContent)
+	stats.countNormalizedTokens(accumulatedContent)
+
+	return timeToFirstToken, completionTokens, promptTokens, true, nil
+}
+
+// countNormalizedTokens recounts the response text with the configured tokenizer, if any.
+func (stats *RequestStats) countNormalizedTokens(content string) {
+	if stats.Tokenizer != nil {
+		stats.NormalizedTokens = stats.Tokenizer(content)
 	}
+}
 
-	return timeToFirstToken, completionTokens, promptTokens, nil
+// hashResponse returns the FNV-1a hash of a response, 0 for an empty one.
+func hashResponse(content string) uint64 {
+	if content == "" {
+		return 0
+	}
+	h := fnv.New64a()
+	h.Write([]byte(content))
+	return h.Sum64()
 }
 
-func AskOpenAiRandomInput(client *openai.Client, model string, numWords int, maxTokens int, useMaxCompletionTokens bool, bar *progressbar.ProgressBar) (float64, int, int, error) {
-	prompt := generateRandomPhrase(numWords)
-	return AskOpenAi(client, model, prompt, maxTokens, useMaxCompletionTokens, bar)
+// AskOpenAiRandomInput is AskOpenAi with a random prompt of numWords words instead of opts.Prompt.
+func AskOpenAiRandomInput(client *openai.Client, numWords int, opts AskOptions) (float64, int, int, error) {
+	opts.Prompt = generateRandomPhrase(numWords)
+	return AskOpenAi(client, opts)
+}
+
+// buildMessages splits the prompt word-wise into numMessages chunks. Roles alternate between user
+// and assistant, starting and ending with a user message as chat templates require. An even count
+// sends the first chunk as the system message instead. A prompt with fewer words than numMessages
+// is sent as a single message.
+func buildMessages(prompt string, numMessages int) []openai.ChatCompletionMessage {
+	words := strings.Fields(prompt)
+	if numMessages <= 1 || len(words) < numMessages {
+		return []openai.ChatCompletionMessage{
+			{
+				Role:    openai.ChatMessageRoleUser,
+				Content: prompt,
+			},
+		}
+	}
+
+	messages := make([]openai.ChatCompletionMessage, numMessages)
+	chunkSize := len(words) / numMessages
+	for i := 0; i < numMessages; i++ {
+		start := i * chunkSize
+		end := start + chunkSize
+		if i == numMessages-1 {
+			end = len(words)
+		}
+
+		role := openai.ChatMessageRoleUser
+		if i == 0 && numMessages%2 == 0 {
+			role = openai.ChatMessageRoleSystem
+		} else if (numMessages-1-i)%2 == 1 {
+			role = openai.ChatMessageRoleAssistant
+		}
+		messages[i] = openai.ChatCompletionMessage{
+			Role:    role,
+			Content: strings.Join(words[start:end], " "),
+		}
+	}
+	return messages
 }
 
 func estimateTokens(content string) int {
@@ -139,16 +433,73 @@ func estimateTokens(content string) int {
 	}
 }
 
-// GetFirstAvailableModel retrieves the first available model from the OpenAI API.
-func GetFirstAvailableModel(client *openai.Client) (string, error) {
+// ErrModelDiscoveryFailed is returned when the models offered by the server could not be listed.
+// It wraps the underlying error; StatusCode is 404 for servers that do not implement /models.
+type ErrModelDiscoveryFailed struct {
+	StatusCode int    // HTTP status of the /models response, 0 if none was received
+	Body       string // Start of the response body, for debugging
+	Err        error
+}
+
+func (e *ErrModelDiscoveryFailed) Error() string {
+	msg := "model discovery failed"
+	if e.StatusCode != 0 {
+		msg += fmt.Sprintf(" with status %d", e.StatusCode)
+	}
+	if e.Body != "" {
+		return msg + ": " + e.Body
+	}
+	return msg + ": " + e.Err.Error()
+}
+
+func (e *ErrModelDiscoveryFailed) Unwrap() error {
+	return e.Err
+}
+
+// maxErrorBodyBytes limits how much of a response body is included in errors.
+const maxErrorBodyBytes = 200
+
+// listModels lists the models offered by the server and turns failures into ErrModelDiscoveryFailed.
+func listModels(client *openai.Client) ([]openai.Model, error) {
 	modelList, err := client.ListModels(context.Background())
 	if err != nil {
-		return "", fmt.Errorf("failed to list models: %w", err)
+		discoveryErr := &ErrModelDiscoveryFailed{Err: err}
+		var requestErr *openai.RequestError
+		var apiErr *openai.APIError
+		switch {
+		case errors.As(err, &requestErr):
+			discoveryErr.StatusCode = requestErr.HTTPStatusCode
+			discoveryErr.Body = string(requestErr.Body[:min(len(requestErr.Body), maxErrorBodyBytes)])
+		case errors.As(err, &apiErr):
+			discoveryErr.StatusCode = apiErr.HTTPStatusCode
+		}
+		return nil, discoveryErr
 	}
-
 	if len(modelList.Models) == 0 {
-		return "", fmt.Errorf("no models available")
+		return nil, &ErrModelDiscoveryFailed{Err: errors.New("the server returned an empty model list")}
+	}
+	return modelList.Models, nil
+}
+
+// ListModelIDs returns the IDs of all models offered by the server.
+func ListModelIDs(client *openai.Client) ([]string, error) {
+	models, err := listModels(client)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(models))
+	for _, model := range models {
+		ids = append(ids, model.ID)
 	}
+	return ids, nil
+}
 
-	return modelList.Models[0].ID, nil
+// GetFirstAvailableModel retrieves the first available model from the OpenAI API.
+func GetFirstAvailableModel(client *openai.Client) (string, error) {
+	models, err := listModels(client)
+	if err != nil {
+		return "", err
+	}
+	return models[0].ID, nil
 }
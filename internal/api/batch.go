@@ -0,0 +1,141 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// BatchResult describes one completed batch job.
+type BatchResult struct {
+	BatchSize             int     `json:"batch_size" yaml:"batch-size"`
+	BatchID               string  `json:"batch_id" yaml:"batch-id"`
+	Status                string  `json:"status" yaml:"status"`
+	CompletedRequests     int     `json:"completed_requests" yaml:"completed-requests"`
+	FailedRequests        int     `json:"failed_requests" yaml:"failed-requests"`
+	TotalPromptTokens     int     `json:"total_prompt_tokens" yaml:"total-prompt-tokens"`
+	TotalCompletionTokens int     `json:"total_completion_tokens" yaml:"total-completion-tokens"`
+	Turnaround            float64 `json:"turnaround" yaml:"turnaround"` // Seconds from submission to results
+	TotalThroughput       float64 `json:"total_throughput" yaml:"total-throughput"`
+
+	// Only set when prices are known, see SetCost
+	Cost                 float64 `json:"cost,omitempty" yaml:"cost,omitempty"`                                       // Of the batch at the discounted batch price
+	CostPerMillionTokens float64 `json:"cost_per_million_tokens,omitempty" yaml:"cost-per-million-tokens,omitempty"` // Prompt and completion tokens together
+	Savings              float64 `json:"savings,omitempty" yaml:"savings,omitempty"`                                 // Over sending the same tokens as regular requests
+}
+
+// SetCost prices the tokens of the batch. The prices are per 1M tokens of regular requests, batch jobs
+// are billed with discount (0.5 for half price) taken off.
+func (result *BatchResult) SetCost(pricePromptPerM, priceCompletionPerM, discount float64) {
+	regular := (float64(result.TotalPromptTokens)*pricePromptPerM + float64(result.TotalCompletionTokens)*priceCompletionPerM) / 1e6
+	result.Cost = regular * (1 - discount)
+	result.Savings = regular - result.Cost
+	if tokens := result.TotalPromptTokens + result.TotalCompletionTokens; tokens > 0 {
+		result.CostPerMillionTokens = result.Cost / float64(tokens) * 1e6
+	}
+}
+
+// batchOutputLine is one line of a batch output file.
+type batchOutputLine struct {
+	CustomID string `json:"custom_id"`
+	Response *struct {
+		StatusCode int                           `json:"status_code"`
+		Body       openai.ChatCompletionResponse `json:"body"`
+	} `json:"response"`
+}
+
+// AskOpenAiBatch submits batchSize copies of the prompt as one batch job, polls until the job
+// reaches a final state and reads back token usage from the output file. With numWords above 0,
+// every request gets its own random prompt of that many words instead. Only the model, prompt,
+// token limit and number of messages of opts are used.
+func AskOpenAiBatch(client *openai.Client, opts AskOptions, numWords int, batchSize int, pollInterval time.Duration) (BatchResult, error) {
+	ctx := context.Background()
+	result := BatchResult{BatchSize: batchSize}
+
+	request := openai.CreateBatchWithUploadFileRequest{
+		Endpoint:         openai.BatchEndpointChatCompletions,
+		CompletionWindow: "24h",
+	}
+	for i := 0; i < batchSize; i++ {
+		prompt := opts.Prompt
+		if numWords > 0 {
+			prompt = generateRandomPhrase(numWords)
+		}
+		body := openai.ChatCompletionRequest{
+			Model:    opts.Model,
+			Messages: buildMessages(prompt, opts.NumMessages),
+		}
+		if opts.UseMaxCompletionTokens {
+			body.MaxCompletionTokens = opts.MaxTokens
+		} else {
+			body.MaxTokens = opts.MaxTokens
+		}
+		request.AddChatCompletion("request-"+strconv.Itoa(i), body)
+	}
+
+	start := time.Now()
+	batch, err := client.CreateBatchWithUploadFile(ctx, request)
+	if err != nil {
+		return result, fmt.Errorf("batch submission failed: %w", err)
+	}
+	result.BatchID = batch.ID
+
+	// Poll until the batch is done
+	for !isFinalBatchStatus(batch.Status) {
+		time.Sleep(pollInterval)
+		batch, err = client.RetrieveBatch(ctx, batch.ID)
+		if err != nil {
+			return result, fmt.Errorf("batch %s: polling failed: %w", result.BatchID, err)
+		}
+	}
+	result.Turnaround = time.Since(start).Seconds()
+	result.Status = batch.Status
+	result.CompletedRequests = batch.RequestCounts.Completed
+	result.FailedRequests = batch.RequestCounts.Failed
+
+	if batch.OutputFileID == nil {
+		return result, fmt.Errorf("batch %s finished with status %s and no output file", result.BatchID, batch.Status)
+	}
+
+	content, err := client.GetFileContent(ctx, *batch.OutputFileID)
+	if err != nil {
+		return result, fmt.Errorf("batch %s: reading output failed: %w", result.BatchID, err)
+	}
+	defer content.Close()
+
+	scanner := bufio.NewScanner(content)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var line batchOutputLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return result, fmt.Errorf("batch %s: invalid output line: %w", result.BatchID, err)
+		}
+		if line.Response == nil {
+			continue
+		}
+		result.TotalPromptTokens += line.Response.Body.Usage.PromptTokens
+		result.TotalCompletionTokens += line.Response.Body.Usage.CompletionTokens
+	}
+	if err := scanner.Err(); err != nil {
+		return result, fmt.Errorf("batch %s: reading output failed: %w", result.BatchID, err)
+	}
+
+	if result.Turnaround > 0 {
+		result.TotalThroughput = float64(result.TotalPromptTokens+result.TotalCompletionTokens) / result.Turnaround
+	}
+
+	return result, nil
+}
+
+func isFinalBatchStatus(status string) bool {
+	switch status {
+	case "completed", "failed", "expired", "cancelled":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,28 @@
+package api
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBatchResultSetCost(t *testing.T) {
+	result := BatchResult{TotalPromptTokens: 300_000, TotalCompletionTokens: 200_000}
+	// $2.50 per 1M prompt and $10 per 1M completion tokens make 2.75 for regular requests, 40% off
+	result.SetCost(2.5, 10, 0.4)
+
+	for name, got := range map[string][2]float64{
+		"Cost":                 {result.Cost, 1.65},
+		"Savings":              {result.Savings, 1.1},
+		"CostPerMillionTokens": {result.CostPerMillionTokens, 3.3},
+	} {
+		if math.Abs(got[0]-got[1]) > 1e-9 {
+			t.Errorf("%s = %v, want %v", name, got[0], got[1])
+		}
+	}
+
+	empty := BatchResult{}
+	empty.SetCost(2.5, 10, 0.5)
+	if empty.Cost != 0 || empty.CostPerMillionTokens != 0 {
+		t.Errorf("empty batch cost %v, %v per 1M tokens, want 0", empty.Cost, empty.CostPerMillionTokens)
+	}
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestBuildMessages(t *testing.T) {
+	const (
+		system    = openai.ChatMessageRoleSystem
+		user      = openai.ChatMessageRoleUser
+		assistant = openai.ChatMessageRoleAssistant
+	)
+	tests := []struct {
+		name        string
+		prompt      string
+		numMessages int
+		roles       []string
+	}{
+		{name: "single message", prompt: "a b c d", numMessages: 1, roles: []string{user}},
+		{name: "odd count", prompt: "a b c d e f", numMessages: 3, roles: []string{user, assistant, user}},
+		{name: "even count", prompt: "a b c d e f", numMessages: 4, roles: []string{system, user, assistant, user}},
+		{name: "two messages", prompt: "a b", numMessages: 2, roles: []string{system, user}},
+		{name: "fewer words than messages", prompt: "a b", numMessages: 3, roles: []string{user}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages := buildMessages(tt.prompt, tt.numMessages)
+			var roles, words []string
+			for _, message := range messages {
+				roles = append(roles, message.Role)
+				words = append(words, message.Content)
+			}
+			if strings.Join(roles, ",") != strings.Join(tt.roles, ",") {
+				t.Errorf("roles = %v, want %v", roles, tt.roles)
+			}
+			if got := strings.Join(words, " "); got != tt.prompt {
+				t.Errorf("contents join to %q, want the prompt %q", got, tt.prompt)
+			}
+		})
+	}
+}
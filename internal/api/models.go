@@ -0,0 +1,56 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// modelEntry covers the context-length fields reported by common OpenAI-compatible servers:
+// vLLM (max_model_len), OpenRouter and LM Studio (context_length) and Groq (context_window).
+type modelEntry struct {
+	ID            string `json:"id"`
+	MaxModelLen   int    `json:"max_model_len"`
+	ContextLength int    `json:"context_length"`
+	ContextWindow int    `json:"context_window"`
+}
+
+// GetModelContextWindow returns the context window of model as reported by the /models endpoint.
+// The plain OpenAI API does not report it, in which case an error is returned.
+func GetModelContextWindow(httpClient *http.Client, baseURL string, apiKey string, model string) (int, error) {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(baseURL, "/")+"/models", nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list models: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("failed to list models: %s", resp.Status)
+	}
+
+	var modelList struct {
+		Data []modelEntry `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&modelList); err != nil {
+		return 0, fmt.Errorf("failed to decode model list: %w", err)
+	}
+
+	for _, entry := range modelList.Data {
+		if entry.ID != model {
+			continue
+		}
+		for _, window := range []int{entry.MaxModelLen, entry.ContextLength, entry.ContextWindow} {
+			if window > 0 {
+				return window, nil
+			}
+		}
+		return 0, fmt.Errorf("model %s does not report a context window", model)
+	}
+	return 0, fmt.Errorf("model %s not found", model)
+}
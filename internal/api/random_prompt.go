@@ -1,6 +1,7 @@
 package api
 
 import (
+	"fmt"
 	"math/rand"
 	"strings"
 	"time"
@@ -14,14 +15,14 @@ const (
 var letters = []rune("abcdefghijklmnopqrstuvwxyz")
 
 // generateRandomWord
-func generateRandomWord() string {
+func generateRandomWord(intn func(int) int) string {
 	// length（3-10）
-	wordLength := minWordLength + rand.Intn(maxWordLength-minWordLength+1)
+	wordLength := minWordLength + intn(maxWordLength-minWordLength+1)
 
 	word := make([]rune, wordLength)
 
 	for i := 0; i < wordLength; i++ {
-		word[i] = letters[rand.Intn(len(letters))]
+		word[i] = letters[intn(len(letters))]
 	}
 
 	return string(word)
@@ -30,10 +31,24 @@ func generateRandomWord() string {
 // generateRandomPhrase
 func generateRandomPhrase(numWords int) string {
 	rand.Seed(time.Now().UnixNano())
+	return randomPhrase(rand.Intn, numWords)
+}
+
+// GenerateSeededPhrase returns a random prompt like the one of AskOpenAiRandomInput, drawn from a
+// generator seeded with seed. The same seed always yields the same prompt.
+func GenerateSeededPhrase(seed int64, numWords int) string {
+	return randomPhrase(rand.New(rand.NewSource(seed)).Intn, numWords)
+}
+
+// PrefixNonce prepends a nonce derived from seed to prompt, so that no two seeds share a prompt prefix.
+func PrefixNonce(seed int64, prompt string) string {
+	return fmt.Sprintf("[%016x] %s", rand.New(rand.NewSource(seed)).Uint64(), prompt)
+}
 
+func randomPhrase(intn func(int) int, numWords int) string {
 	randomWords := make([]string, numWords)
 	for i := 0; i < numWords; i++ {
-		randomWords[i] = generateRandomWord()
+		randomWords[i] = generateRandomWord(intn)
 	}
 
 	randomPhrase := strings.Join(randomWords, " ")
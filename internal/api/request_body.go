@@ -0,0 +1,32 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// RequestBodyTransport replaces the body of every chat completion request with Body, so that requests
+// the command-line flags cannot express are sent as they are. The streamed response is parsed as usual.
+type RequestBodyTransport struct {
+	Base http.RoundTripper
+	Body []byte
+}
+
+func (t *RequestBodyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPost || !strings.HasSuffix(req.URL.Path, "/chat/completions") {
+		return t.Base.RoundTrip(req)
+	}
+
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(bytes.NewReader(t.Body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(t.Body)), nil
+	}
+	req.ContentLength = int64(len(t.Body))
+	return t.Base.RoundTrip(req)
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// RequestError is returned when the server answers a benchmark request with an error, either with an
+// error status or with an error event within the stream. It wraps the underlying error.
+type RequestError struct {
+	StatusCode int           // HTTP status, 0 for errors sent within the stream
+	ErrorType  string        // Type or code the server gave the error, if any
+	Message    string        // Error message of the server, or the start of the response body
+	RequestID  string        // Value of the x-request-id response header, if sent
+	RetryAfter time.Duration // Value of the Retry-After response header, 0 if not sent
+	Err        error
+}
+
+func (e *RequestError) Error() string {
+	msg := "request failed"
+	if e.StatusCode != 0 {
+		msg += fmt.Sprintf(" with status %d", e.StatusCode)
+	}
+	if e.ErrorType != "" {
+		msg += " (" + e.ErrorType + ")"
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.RequestID != "" {
+		msg += " [request id " + e.RequestID + "]"
+	}
+	return msg
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// IsRateLimited reports whether the server rejected the request with 429 Too Many Requests. OpenAI
+// also answers exhausted quotas with 429, those are reported by IsQuotaExceeded instead.
+func (e *RequestError) IsRateLimited() bool {
+	return e.StatusCode == http.StatusTooManyRequests && !e.IsQuotaExceeded()
+}
+
+// quotaErrorTypes are the error codes servers use for an account that is out of quota or credit.
+var quotaErrorTypes = []string{"insufficient_quota", "quota_exceeded", "billing_hard_limit_reached", "insufficient_balance"}
+
+// IsQuotaExceeded reports whether the request was rejected because the account ran out of quota or
+// credit, with 402 Payment Required or one of the quota error codes. Retrying does not help.
+func (e *RequestError) IsQuotaExceeded() bool {
+	if e.StatusCode == http.StatusPaymentRequired || slices.Contains(quotaErrorTypes, strings.ToLower(e.ErrorType)) {
+		return true
+	}
+	message := strings.ToLower(e.Message)
+	return strings.Contains(message, "insufficient quota") || strings.Contains(message, "exceeded your current quota")
+}
+
+// responseHeaderKey is the context key of the slot that RecordResponseHeader fills.
+type responseHeaderKey struct{}
+
+// withResponseHeader returns ctx with a slot that receives the header of the response to the request.
+// go-openai drops the headers of error responses, the request ID and Retry-After are taken from it.
+func withResponseHeader(ctx context.Context, header *http.Header) context.Context {
+	return context.WithValue(ctx, responseHeaderKey{}, header)
+}
+
+// RecordResponseHeader stores the header of resp in the slot of the request's context, if it has one.
+// Transports wrapping the client of AskOpenAi call it so that errors carry the request ID and Retry-After.
+func RecordResponseHeader(req *http.Request, resp *http.Response) {
+	if header, ok := req.Context().Value(responseHeaderKey{}).(*http.Header); ok {
+		*header = resp.Header
+	}
+}
+
+// newRequestError returns the RequestError for an error status response with the given body.
+// Both the OpenAI error format and a plain {"error": "message"} are understood.
+func newRequestError(resp *http.Response, body []byte) *RequestError {
+	requestErr := requestErrorFromHeader(resp.StatusCode, resp.Header)
+	var structured struct {
+		Error *struct {
+			Message string `json:"message"`
+			Type    string `json:"type"`
+			Code    any    `json:"code"`
+		} `json:"error"`
+	}
+	var plain struct {
+		Error string `json:"error"`
+	}
+	switch {
+	case json.Unmarshal(body, &structured) == nil && structured.Error != nil:
+		requestErr.Message = structured.Error.Message
+		requestErr.ErrorType = errorType(structured.Error.Type, structured.Error.Code)
+	case json.Unmarshal(body, &plain) == nil && plain.Error != "":
+		requestErr.Message = plain.Error
+	default:
+		requestErr.Message = strings.TrimSpace(string(body[:min(len(body), maxErrorBodyBytes)]))
+	}
+	requestErr.Err = errors.New(resp.Status)
+	return requestErr
+}
+
+// streamError returns the RequestError for an error event sent within the stream.
+func streamError(message string) *RequestError {
+	return &RequestError{Message: message, Err: errors.New(message)}
+}
+
+// toRequestError converts the errors go-openai returns for error responses into a RequestError,
+// with the header recorded for the request. Other errors are returned unchanged.
+func toRequestError(err error, header http.Header) error {
+	var apiErr *openai.APIError
+	var openaiErr *openai.RequestError
+	switch {
+	case errors.As(err, &apiErr):
+		requestErr := requestErrorFromHeader(apiErr.HTTPStatusCode, header)
+		requestErr.Message = apiErr.Message
+		requestErr.ErrorType = errorType(apiErr.Type, apiErr.Code)
+		requestErr.Err = err
+		return requestErr
+	case errors.As(err, &openaiErr):
+		requestErr := requestErrorFromHeader(openaiErr.HTTPStatusCode, header)
+		requestErr.Message = strings.TrimSpace(string(openaiErr.Body[:min(len(openaiErr.Body), maxErrorBodyBytes)]))
+		requestErr.Err = err
+		return requestErr
+	default:
+		return err
+	}
+}
+
+func requestErrorFromHeader(statusCode int, header http.Header) *RequestError {
+	return &RequestError{
+		StatusCode: statusCode,
+		RequestID:  header.Get("X-Request-Id"),
+		RetryAfter: parseRetryAfter(header.Get("Retry-After")),
+	}
+}
+
+// errorType returns the error code if the server sent one, as it is more specific, the type otherwise.
+func errorType(typ string, code any) string {
+	if code != nil && code != "" {
+		return fmt.Sprint(code)
+	}
+	return typ
+}
+
+// parseRetryAfter parses a Retry-After value in seconds or as HTTP date, 0 if it is missing or invalid.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(0, time.Until(date))
+	}
+	return 0
+}
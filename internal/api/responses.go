@@ -0,0 +1,279 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// ResponsesClient sends requests to the Responses API (/responses). go-openai only supports
+// chat completions, so requests and the event stream are handled here directly.
+type ResponsesClient struct {
+	HTTPClient *http.Client
+	BaseURL    string
+	APIKey     string
+	APIVersion string
+}
+
+// responsesEvent covers the fields of the streaming events used for the metrics.
+type responsesEvent struct {
+	Type    string `json:"type"`
+	Delta   string `json:"delta"`
+	Message string `json:"message"` // Set on error events
+	Item    *struct {
+		Type string `json:"type"`
+		Name string `json:"name"`
+	} `json:"item"`
+	Response *struct {
+		Status            string `json:"status"`
+		IncompleteDetails *struct {
+			Reason string `json:"reason"`
+		} `json:"incomplete_details"`
+		Error *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+		Usage *struct {
+			InputTokens  int `json:"input_tokens"`
+			OutputTokens int `json:"output_tokens"`
+		} `json:"usage"`
+	} `json:"response"`
+}
+
+// AskOpenAiResponses is AskOpenAi for the Responses API. TTFT is taken from the first
+// response.output_text.delta (or function call) event and token counts from the usage
+// of the response.completed event. UseMaxCompletionTokens, Seed and Stop are ignored, the
+// Responses API only knows max_output_tokens and neither of the others.
+func AskOpenAiResponses(client *ResponsesClient, opts AskOptions) (float64, int, int, error) {
+	stats := opts.stats()
+	start := time.Now()
+
+	return withRetries(opts.MaxRetries, stats, func() (float64, int, int, bool, error) {
+		return askResponsesOnce(client, opts, start, stats)
+	})
+}
+
+// AskOpenAiResponsesRandomInput is AskOpenAiResponses with a random prompt of numWords words.
+func AskOpenAiResponsesRandomInput(client *ResponsesClient, numWords int, opts AskOptions) (float64, int, int, error) {
+	opts.Prompt = generateRandomPhrase(numWords)
+	return AskOpenAiResponses(client, opts)
+}
+
+func askResponsesOnce(client *ResponsesClient, opts AskOptions, start time.Time, stats *RequestStats) (float64, int, int, bool, error) {
+	prompt, bar := opts.Prompt, opts.Bar
+	var (
+		timeToFirstToken   float64
+		firstTokenSeen     bool
+		accumulatedContent string
+		estimatedTokens    int
+		promptTokens       int
+		completionTokens   int
+		usageSeen          bool
+	)
+
+	ctx, cancel := context.WithCancel(stats.traceConnection(context.Background()))
+	defer cancel()
+	defer stats.startSoftDeadline(start, cancel)()
+	resp, err := client.post(ctx, opts)
+	if err != nil {
+		if stats.abandon() {
+			return 0, 0, 0, false, nil
+		}
+		return 0, 0, 0, false, fmt.Errorf("OpenAI API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	stats.ResponseHeader = resp.Header
+
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if errors.Is(err, io.EOF) && line == "" {
+			break
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			if stats.abandon() {
+				break
+			}
+			return 0, 0, 0, accumulatedContent != "", fmt.Errorf("stream error: %w", err)
+		}
+
+		// Event names are repeated in the data payload, so only data lines are parsed
+		data, ok := strings.CutPrefix(strings.TrimSpace(line), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			break
+		}
+		var event responsesEvent
+		if jsonErr := json.Unmarshal([]byte(data), &event); jsonErr != nil {
+			return 0, 0, 0, accumulatedContent != "", fmt.Errorf("stream error: %w", jsonErr)
+		}
+
+		var content string
+		switch event.Type {
+		case "response.output_text.delta":
+			content = event.Delta
+		case "response.function_call_arguments.delta":
+			stats.ToolCall = true
+			content = event.Delta
+		case "response.output_item.added":
+			if event.Item != nil && event.Item.Type == "function_call" {
+				stats.ToolCall = true
+				content = event.Item.Name
+			}
+		case "response.completed", "response.incomplete":
+			stats.FinishReason = "stop"
+			if event.Response != nil && event.Response.IncompleteDetails != nil {
+				stats.FinishReason = event.Response.IncompleteDetails.Reason
+				if stats.FinishReason == "max_output_tokens" {
+					// Reported like the chat completions API so both count as truncated
+					stats.FinishReason = string(openai.FinishReasonLength)
+				}
+			}
+			if event.Response != nil && event.Response.Usage != nil {
+				promptTokens = event.Response.Usage.InputTokens
+				completionTokens = event.Response.Usage.OutputTokens
+				usageSeen = true
+			}
+		case "response.failed":
+			message := "response failed"
+			if event.Response != nil && event.Response.Error != nil {
+				message = event.Response.Error.Message
+			}
+			return 0, 0, 0, accumulatedContent != "", fmt.Errorf("stream error: %w", streamError(message))
+		case "error":
+			return 0, 0, 0, accumulatedContent != "", fmt.Errorf("stream error: %w", streamError(event.Message))
+		}
+
+		if !firstTokenSeen && strings.TrimSpace(content) != "" {
+			timeToFirstToken = time.Since(start).Seconds()
+			firstTokenSeen = true
+		}
+		if content != "" {
+			accumulatedContent += content
+			newTokens := estimateTokens(content)
+			estimatedTokens += newTokens
+			if bar != nil {
+				bar.Add(newTokens)
+				stats.BarTokens += newTokens
+			}
+			if stats.StreamedTokens != nil {
+				stats.StreamedTokens.Add(int64(newTokens))
+			}
+		}
+		if stats.readLimitReached(estimatedTokens) {
+			cancel()
+			break
+		}
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+	}
+
+	if usageSeen {
+		if bar != nil && completionTokens > 0 {
+			if diff := completionTokens - estimatedTokens; diff != 0 {
+				bar.Add(diff)
+				stats.BarTokens += diff
+			}
+		}
+	} else if stats.PartialRead || stats.Abandoned {
+		// Cancelled before the usage was sent, the prompt is estimated as well
+		completionTokens = estimatedTokens
+		promptTokens = estimateTokens(prompt)
+	} else {
+		completionTokens = estimatedTokens
+		stats.UsageMissing = true
+	}
+	stats.ResponseHash = hashResponse(accumulatedContent)
+	stats.countNormalizedTokens(accumulatedContent)
+
+	return timeToFirstToken, completionTokens, promptTokens, true, nil
+}
+
+// post starts a streaming Responses API request.
+func (client *ResponsesClient) post(ctx context.Context, opts AskOptions) (*http.Response, error) {
+	var input []map[string]string
+	for _, message := range buildMessages(opts.Prompt, opts.NumMessages) {
+		input = append(input, map[string]string{"role": message.Role, "content": message.Content})
+	}
+	body := map[string]any{
+		"model":       opts.Model,
+		"input":       input,
+		"temperature": opts.temperature(),
+		"stream":      true,
+	}
+	if opts.MaxTokens > 0 {
+		body["max_output_tokens"] = opts.MaxTokens
+	}
+	if tools := opts.Tools; tools != nil {
+		body["tools"] = responsesTools(tools.Tools)
+		if tools.ToolChoice != nil {
+			body["tool_choice"] = responsesToolChoice(tools.ToolChoice)
+		}
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := strings.TrimRight(client.BaseURL, "/") + "/responses"
+	if client.APIVersion != "" {
+		endpoint += "?api-version=" + url.QueryEscape(client.APIVersion)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+client.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := client.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, newRequestError(resp, body)
+	}
+	return resp, nil
+}
+
+// responsesTools converts chat completion tools to the flat function format of the Responses API.
+func responsesTools(tools []openai.Tool) []map[string]any {
+	converted := make([]map[string]any, 0, len(tools))
+	for _, tool := range tools {
+		if tool.Function == nil {
+			continue
+		}
+		converted = append(converted, map[string]any{
+			"type":        "function",
+			"name":        tool.Function.Name,
+			"description": tool.Function.Description,
+			"parameters":  tool.Function.Parameters,
+		})
+	}
+	return converted
+}
+
+// responsesToolChoice converts a tool choice from ParseToolChoice to the Responses API format.
+func responsesToolChoice(choice any) any {
+	if named, ok := choice.(openai.ToolChoice); ok {
+		return map[string]string{"type": "function", "name": named.Function.Name}
+	}
+	return choice
+}
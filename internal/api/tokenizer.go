@@ -0,0 +1,30 @@
+package api
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// Tokenizers that can recount completion tokens locally, see NewTokenizer.
+const (
+	TokenizerWords = "words" // ~1.3 tokens per word, the estimate used when a server sends no usage
+	TokenizerChars = "chars" // ~4 characters per token, the common rule of thumb for English text
+)
+
+// Tokenizer counts the tokens of a text. Providers count differently, some include special tokens,
+// recounting every response with the same tokenizer makes throughputs comparable across them.
+type Tokenizer func(text string) int
+
+// NewTokenizer returns the tokenizer with the given name.
+func NewTokenizer(name string) (Tokenizer, error) {
+	switch name {
+	case TokenizerWords:
+		return estimateTokens, nil
+	case TokenizerChars:
+		return func(text string) int {
+			return (utf8.RuneCountInString(text) + 3) / 4
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown tokenizer '%s', expected '%s' or '%s'", name, TokenizerWords, TokenizerChars)
+	}
+}
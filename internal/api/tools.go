@@ -0,0 +1,72 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// ToolConfig holds the function-calling setup attached to every chat request.
+type ToolConfig struct {
+	Tools      []openai.Tool
+	ToolChoice any // nil, "auto", "none", "required" or an openai.ToolChoice
+}
+
+// LoadTools reads tool definitions from a JSON file. Both a plain array of tools and
+// an object with a "tools" array (as in a chat completion request) are accepted.
+func LoadTools(path string) ([]openai.Tool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading tools file: %w", err)
+	}
+
+	var tools []openai.Tool
+	if err := json.Unmarshal(data, &tools); err != nil {
+		var wrapper struct {
+			Tools []openai.Tool `json:"tools"`
+		}
+		if err := json.Unmarshal(data, &wrapper); err != nil {
+			return nil, fmt.Errorf("error parsing tools file: %w", err)
+		}
+		tools = wrapper.Tools
+	}
+	if len(tools) == 0 {
+		return nil, fmt.Errorf("no tools defined in %s", path)
+	}
+
+	for i := range tools {
+		if tools[i].Type == "" {
+			tools[i].Type = openai.ToolTypeFunction
+		}
+	}
+	return tools, nil
+}
+
+// ParseToolChoice turns a --tool-choice value into a request tool_choice. Anything other than
+// "auto", "none" and "required" is taken as the name of the function the model must call.
+func ParseToolChoice(choice string) any {
+	switch choice {
+	case "":
+		return nil
+	case "auto", "none", "required":
+		return choice
+	default:
+		return openai.ToolChoice{
+			Type:     openai.ToolTypeFunction,
+			Function: openai.ToolFunction{Name: choice},
+		}
+	}
+}
+
+// deltaContent returns the streamed text of a chunk. Tool calls stream their name and arguments
+// instead of content, those are counted as generated text as well.
+func deltaContent(delta openai.ChatCompletionStreamChoiceDelta, stats *RequestStats) string {
+	content := delta.Content
+	for _, toolCall := range delta.ToolCalls {
+		stats.ToolCall = true
+		content += toolCall.Function.Name + toolCall.Function.Arguments
+	}
+	return content
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TritonClient sends requests to the generate_stream endpoint of Triton's HTTP inference protocol
+// (/v2/models/<model>/generate_stream), as served e.g. by the TensorRT-LLM backend.
+// BaseURL is the server root, e.g. http://localhost:8000.
+type TritonClient struct {
+	HTTPClient *http.Client
+	BaseURL    string
+	APIKey     string // Sent as bearer token if set, Triton itself does not require one
+}
+
+// tritonEvent covers the fields of the streamed generate responses used for the metrics.
+type tritonEvent struct {
+	TextOutput string `json:"text_output"`
+	Error      string `json:"error"`
+}
+
+// AskTriton is AskOpenAi for Triton. The generate extension reports no usage, so prompt and
+// completion tokens are always estimated. Messages are joined into one text input.
+// UseMaxCompletionTokens, Tools, Seed and Stop are not supported by the protocol and ignored.
+func AskTriton(client *TritonClient, opts AskOptions) (float64, int, int, error) {
+	stats := opts.stats()
+	start := time.Now()
+
+	return withRetries(opts.MaxRetries, stats, func() (float64, int, int, bool, error) {
+		return askTritonOnce(client, opts, start, stats)
+	})
+}
+
+// AskTritonRandomInput is AskTriton with a random prompt of numWords words.
+func AskTritonRandomInput(client *TritonClient, numWords int, opts AskOptions) (float64, int, int, error) {
+	opts.Prompt = generateRandomPhrase(numWords)
+	return AskTriton(client, opts)
+}
+
+func askTritonOnce(client *TritonClient, opts AskOptions, start time.Time, stats *RequestStats) (float64, int, int, bool, error) {
+	bar := opts.Bar
+	var (
+		timeToFirstToken   float64
+		firstTokenSeen     bool
+		accumulatedContent string
+		estimatedTokens    int
+	)
+
+	var messages []string
+	for _, message := range buildMessages(opts.Prompt, opts.NumMessages) {
+		messages = append(messages, message.Content)
+	}
+	textInput := strings.Join(messages, "\n")
+
+	ctx, cancel := context.WithCancel(stats.traceConnection(context.Background()))
+	defer cancel()
+	defer stats.startSoftDeadline(start, cancel)()
+	resp, err := client.post(ctx, opts.Model, textInput, opts.MaxTokens, opts.temperature())
+	if err != nil {
+		if stats.abandon() {
+			return 0, 0, 0, false, nil
+		}
+		return 0, 0, 0, false, fmt.Errorf("Triton request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	stats.ResponseHeader = resp.Header
+
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if errors.Is(err, io.EOF) && line == "" {
+			break
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			if stats.abandon() {
+				break
+			}
+			return 0, 0, 0, accumulatedContent != "", fmt.Errorf("stream error: %w", err)
+		}
+
+		data, ok := strings.CutPrefix(strings.TrimSpace(line), "data:")
+		if !ok {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			continue
+		}
+		var event tritonEvent
+		if jsonErr := json.Unmarshal([]byte(strings.TrimSpace(data)), &event); jsonErr != nil {
+			return 0, 0, 0, accumulatedContent != "", fmt.Errorf("stream error: %w", jsonErr)
+		}
+		if event.Error != "" {
+			return 0, 0, 0, accumulatedContent != "", fmt.Errorf("stream error: %w", streamError(event.Error))
+		}
+
+		content := event.TextOutput
+		if !firstTokenSeen && strings.TrimSpace(content) != "" {
+			timeToFirstToken = time.Since(start).Seconds()
+			firstTokenSeen = true
+		}
+		if content != "" {
+			accumulatedContent += content
+			newTokens := estimateTokens(content)
+			estimatedTokens += newTokens
+			if bar != nil {
+				bar.Add(newTokens)
+				stats.BarTokens += newTokens
+			}
+			if stats.StreamedTokens != nil {
+				stats.StreamedTokens.Add(int64(newTokens))
+			}
+		}
+		if stats.readLimitReached(estimatedTokens) {
+			cancel()
+			break
+		}
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+	}
+
+	// The stream ends once generation is done, there is no finish reason to report
+	stats.FinishReason = "stop"
+	stats.UsageMissing = true
+	stats.ResponseHash = hashResponse(accumulatedContent)
+	stats.countNormalizedTokens(accumulatedContent)
+
+	return timeToFirstToken, estimatedTokens, estimateTokens(textInput), true, nil
+}
+
+// post starts a streaming generate request.
+func (client *TritonClient) post(ctx context.Context, model string, textInput string, maxTokens int, temperature float32) (*http.Response, error) {
+	payload, err := json.Marshal(map[string]any{
+		"text_input":  textInput,
+		"max_tokens":  maxTokens,
+		"temperature": temperature,
+		"stream":      true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := strings.TrimRight(client.BaseURL, "/") + "/v2/models/" + url.PathEscape(model) + "/generate_stream"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	if client.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+client.APIKey)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := client.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, newRequestError(resp, body)
+	}
+	return resp, nil
+}
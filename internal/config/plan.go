@@ -0,0 +1,93 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Plan is a complete benchmark plan, loaded with --plan. It replaces the concurrency levels of the
+// command line, the other settings only override their flags when they are set.
+type Plan struct {
+	MaxTokens int         `json:"max-tokens,omitempty"` // Default of all levels
+	Repeats   int         `json:"repeats,omitempty"`    // Default of all levels
+	Prompt    string      `json:"prompt,omitempty"`     // Default of all levels
+	NumWords  int         `json:"num-words,omitempty"`  // Random prompt of this many words, used unless a prompt is set
+	Levels    []PlanLevel `json:"levels"`               // Run in the given order
+}
+
+// PlanLevel is a concurrency level of a Plan with optional overrides of the plan defaults.
+type PlanLevel struct {
+	Concurrency int    `json:"concurrency"`
+	MaxTokens   int    `json:"max-tokens,omitempty"`
+	Repeats     int    `json:"repeats,omitempty"`
+	Prompt      string `json:"prompt,omitempty"`
+}
+
+// LoadPlan reads and validates the JSON plan in path. Unknown fields are rejected, so that a typo
+// does not silently fall back to a flag.
+func LoadPlan(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var plan Plan
+	if err := decoder.Decode(&plan); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if err := plan.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid plan %s: %w", path, err)
+	}
+	return &plan, nil
+}
+
+// Validate checks that the plan has at least one level, that every concurrency is positive and
+// listed only once and that no token count, repeat count or word count is negative.
+func (plan *Plan) Validate() error {
+	if len(plan.Levels) == 0 {
+		return errors.New("no levels")
+	}
+	if plan.MaxTokens < 0 || plan.Repeats < 0 || plan.NumWords < 0 {
+		return errors.New("max-tokens, repeats and num-words must not be negative")
+	}
+
+	seen := make(map[int]bool, len(plan.Levels))
+	for i, level := range plan.Levels {
+		if level.Concurrency <= 0 {
+			return fmt.Errorf("level %d: concurrency must be positive, got %d", i+1, level.Concurrency)
+		}
+		if seen[level.Concurrency] {
+			return fmt.Errorf("level %d: concurrency %d is listed twice", i+1, level.Concurrency)
+		}
+		seen[level.Concurrency] = true
+		if level.MaxTokens < 0 || level.Repeats < 0 {
+			return fmt.Errorf("level %d: max-tokens and repeats must not be negative", i+1)
+		}
+	}
+	return nil
+}
+
+// ConcurrencyLevels returns the concurrency of every level in plan order.
+func (plan *Plan) ConcurrencyLevels() []int {
+	levels := make([]int, len(plan.Levels))
+	for i, level := range plan.Levels {
+		levels[i] = level.Concurrency
+	}
+	return levels
+}
+
+// LevelOverrides returns the levels that override a plan default, keyed by their concurrency.
+func (plan *Plan) LevelOverrides() map[int]PlanLevel {
+	overrides := make(map[int]PlanLevel)
+	for _, level := range plan.Levels {
+		if level.MaxTokens > 0 || level.Repeats > 0 || level.Prompt != "" {
+			overrides[level.Concurrency] = level
+		}
+	}
+	return overrides
+}
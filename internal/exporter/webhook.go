@@ -0,0 +1,80 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Yoosu-L/llmapibenchmark/internal/utils"
+)
+
+// Summary is the short description of a benchmark run sent to a webhook.
+type Summary struct {
+	ModelName       string  `json:"model_name"`
+	BestConcurrency int     `json:"best_concurrency"`
+	MaxThroughput   float64 `json:"max_throughput"`
+	FailureRate     float64 `json:"failure_rate"`
+	LevelsCompleted int     `json:"levels_completed"`
+	Error           string  `json:"error,omitempty"`
+}
+
+// webhookPayload is Slack-compatible: Slack only reads "text", other receivers can use "summary".
+type webhookPayload struct {
+	Text    string  `json:"text"`
+	Summary Summary `json:"summary"`
+}
+
+// NewSummary condenses the measured levels and the run error (if any) into a Summary.
+func NewSummary(modelName string, results []utils.SpeedResult, runErr error) Summary {
+	summary := Summary{ModelName: modelName, LevelsCompleted: len(results)}
+
+	var successful, failed int
+	for _, result := range results {
+		if result.GenerationSpeed > summary.MaxThroughput {
+			summary.MaxThroughput = result.GenerationSpeed
+			summary.BestConcurrency = result.Concurrency
+		}
+		successful += result.SuccessfulRequests
+		failed += result.FailedRequests
+	}
+	if successful+failed > 0 {
+		summary.FailureRate = float64(failed) / float64(successful+failed)
+	}
+	if runErr != nil {
+		summary.Error = runErr.Error()
+	}
+
+	return summary
+}
+
+// Text renders the summary as a one-message human-readable report.
+func (summary Summary) Text() string {
+	if summary.Error != "" {
+		return fmt.Sprintf("LLM API benchmark for %s failed after %d concurrency level(s): %s",
+			summary.ModelName, summary.LevelsCompleted, summary.Error)
+	}
+	return fmt.Sprintf("LLM API benchmark for %s finished: max generation speed %.2f tokens/s at concurrency %d, failure rate %.2f%% over %d level(s)",
+		summary.ModelName, summary.MaxThroughput, summary.BestConcurrency, summary.FailureRate*100, summary.LevelsCompleted)
+}
+
+// NotifyWebhook POSTs the summary as JSON to url.
+func NotifyWebhook(url string, summary Summary) error {
+	body, err := json.Marshal(webhookPayload{Text: summary.Text(), Summary: summary})
+	if err != nil {
+		return fmt.Errorf("error marshalling webhook payload: %w", err)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package utils
+
+// HighRunToRunCV is the coefficient of variation above which repeated runs are considered unreliable.
+const HighRunToRunCV = 0.1
+
+// HighCompletionLengthCV is the coefficient of variation of completion lengths above which a throughput
+// drop is attributed to stragglers, requests that keep generating after the others finished.
+const HighCompletionLengthCV = 0.5
+
+// HighP95TtftCV is the coefficient of variation of the per-run P95 TTFT above which tail latency is flagged as unstable.
+const HighP95TtftCV = 0.2
+
+// HighDuplicateResponseRate is the share of byte-identical responses to unique prompts above which a
+// caching layer is suspected of returning responses to the wrong requests.
+const HighDuplicateResponseRate = 0.1
+
+// AggregateResults merges repeated measurements of the same concurrency level into one result.
+// Rates, speeds and TTFT statistics are averaged, request and token counts are summed.
+func AggregateResults(runs []SpeedResult) SpeedResult {
+	if len(runs) == 0 {
+		return SpeedResult{}
+	}
+	if len(runs) == 1 {
+		result := runs[0]
+		result.Repeats = 1
+		return result
+	}
+
+	aggregated := SpeedResult{}
+	aggregated.Concurrency = runs[0].Concurrency
+	aggregated.BaseUrl = runs[0].BaseUrl
+	aggregated.InterleavedModel = runs[0].InterleavedModel
+	aggregated.TimeSeries = runs[0].TimeSeries
+	aggregated.Repeats = len(runs)
+
+	aggregated.MinRemainingRateLimit = -1
+
+	n := float64(len(runs))
+	generationSpeeds := make([]float64, 0, len(runs))
+	p95Ttfts := make([]float64, 0, len(runs))
+	for _, run := range runs {
+		generationSpeeds = append(generationSpeeds, run.GenerationSpeed)
+		p95Ttfts = append(p95Ttfts, run.P95Ttft)
+
+		aggregated.GenerationSpeed += run.GenerationSpeed / n
+		aggregated.NormalizedTokenThroughput += run.NormalizedTokenThroughput / n
+		aggregated.PromptThroughput += run.PromptThroughput / n
+		aggregated.PrefillSpeed += run.PrefillSpeed / n
+		aggregated.TotalThroughput += run.TotalThroughput / n
+		aggregated.MaxTtft += run.MaxTtft / n
+		aggregated.MinTtft += run.MinTtft / n
+		aggregated.AvgTtft += run.AvgTtft / n
+		aggregated.MedianTtft += run.MedianTtft / n
+		aggregated.P75Ttft += run.P75Ttft / n
+		aggregated.P95Ttft += run.P95Ttft / n
+		aggregated.P99Ttft += run.P99Ttft / n
+		aggregated.StdDevTtft += run.StdDevTtft / n
+		aggregated.Duration += run.Duration / n
+		aggregated.BurstAvgTtft += run.BurstAvgTtft / n
+		aggregated.SwitchAvgTtft += run.SwitchAvgTtft / n
+		aggregated.SameModelAvgTtft += run.SameModelAvgTtft / n
+		aggregated.ModelSwitchPenalty += run.ModelSwitchPenalty / n
+		aggregated.ConnectionSetupMs += run.ConnectionSetupMs / n
+		aggregated.AvgRequestBytes += run.AvgRequestBytes / n
+		aggregated.AvgResponseBytes += run.AvgResponseBytes / n
+		aggregated.ColdTtft += run.ColdTtft / n
+		aggregated.CompletionLengthStdDev += run.CompletionLengthStdDev / n
+		aggregated.DuplicateResponseRate += run.DuplicateResponseRate / n
+		aggregated.PromptTokensStdDev += run.PromptTokensStdDev / n
+		aggregated.WarmTtft += run.WarmTtft / n
+		aggregated.BurstP95Ttft += run.BurstP95Ttft / n
+		aggregated.SustainedAvgTtft += run.SustainedAvgTtft / n
+		aggregated.SustainedP95Ttft += run.SustainedP95Ttft / n
+		aggregated.NewConnAvgTtft += run.NewConnAvgTtft / n
+		aggregated.NewConnP95Ttft += run.NewConnP95Ttft / n
+		aggregated.ReusedConnAvgTtft += run.ReusedConnAvgTtft / n
+		aggregated.ReusedConnP95Ttft += run.ReusedConnP95Ttft / n
+
+		aggregated.SuccessfulRequests += run.SuccessfulRequests
+		aggregated.FailedRequests += run.FailedRequests
+		aggregated.AbandonedRequests += run.AbandonedRequests
+		aggregated.TotalPromptTokens += run.TotalPromptTokens
+		aggregated.TotalCompletionTokens += run.TotalCompletionTokens
+		aggregated.NormalizedCompletionTokens += run.NormalizedCompletionTokens
+		aggregated.InjectedLatencyCount += run.InjectedLatencyCount
+		aggregated.ConnectionResetRetries += run.ConnectionResetRetries
+		aggregated.UnterminatedStreams += run.UnterminatedStreams
+		aggregated.TruncatedResponses += run.TruncatedResponses
+		aggregated.PartialResponses += run.PartialResponses
+		aggregated.MissingUsageResponses += run.MissingUsageResponses
+		aggregated.ToolCallResponses += run.ToolCallResponses
+		aggregated.TimeoutErrors += run.TimeoutErrors
+		aggregated.RateLimitErrors += run.RateLimitErrors
+		aggregated.QuotaErrors += run.QuotaErrors
+		aggregated.CacheHitEstimate += run.CacheHitEstimate
+		aggregated.ModelSwitches += run.ModelSwitches
+		aggregated.TtftSpikes += run.TtftSpikes
+		aggregated.SwitchTtftSpikes += run.SwitchTtftSpikes
+		aggregated.QueueSamples += run.QueueSamples
+		aggregated.MaxQueueDepth = max(aggregated.MaxQueueDepth, run.MaxQueueDepth)
+		if aggregated.QuotaError == "" {
+			aggregated.QuotaError = run.QuotaError
+		}
+		aggregated.NewConnections += run.NewConnections
+		aggregated.NewConnRequests += run.NewConnRequests
+		aggregated.ReusedConnRequests += run.ReusedConnRequests
+		aggregated.ReusedConnections += run.ReusedConnections
+		aggregated.TimeoutAtTtft = append(aggregated.TimeoutAtTtft, run.TimeoutAtTtft...)
+		aggregated.LatencyAdjustmentSkipped = aggregated.LatencyAdjustmentSkipped || run.LatencyAdjustmentSkipped
+		if run.MinRemainingRateLimit >= 0 && (aggregated.MinRemainingRateLimit < 0 || run.MinRemainingRateLimit < aggregated.MinRemainingRateLimit) {
+			aggregated.MinRemainingRateLimit = run.MinRemainingRateLimit
+		}
+		aggregated.TotalAllocBytes += run.TotalAllocBytes
+		aggregated.NumGC += run.NumGC
+		aggregated.GCPauseMs += run.GCPauseMs
+	}
+
+	totalRequests := aggregated.SuccessfulRequests + aggregated.FailedRequests
+	if totalRequests > 0 {
+		aggregated.SuccessRate = float64(aggregated.SuccessfulRequests) / float64(totalRequests)
+	}
+	if sent := totalRequests + aggregated.AbandonedRequests; sent > 0 {
+		aggregated.AbandonedRate = roundRatio(float64(aggregated.AbandonedRequests) / float64(sent))
+	}
+	if totalRequests > 0 {
+		aggregated.AllocBytesPerRequest = aggregated.TotalAllocBytes / uint64(totalRequests)
+	}
+	if aggregated.SuccessfulRequests > 0 {
+		aggregated.AvgPromptTokens = RoundToPrecision(float64(aggregated.TotalPromptTokens) / float64(aggregated.SuccessfulRequests))
+		aggregated.AvgCompletionTokens = RoundToPrecision(float64(aggregated.TotalCompletionTokens) / float64(aggregated.SuccessfulRequests))
+	}
+
+	// Weighted by the responses that reported the scheduler state
+	if aggregated.QueueSamples > 0 {
+		var depth, running, kvCacheUsage float64
+		for _, run := range runs {
+			weight := float64(run.QueueSamples) / float64(aggregated.QueueSamples)
+			depth += run.AvgQueueDepth * weight
+			running += run.AvgRunningRequests * weight
+			kvCacheUsage += run.AvgKVCacheUsage * weight
+		}
+		aggregated.AvgQueueDepth = RoundToPrecision(depth)
+		aggregated.AvgRunningRequests = RoundToPrecision(running)
+		aggregated.AvgKVCacheUsage = roundRatio(kvCacheUsage)
+	}
+
+	// Weighted by the timeouts of each run rather than averaged per run
+	var timeoutElapsedMs float64
+	for _, run := range runs {
+		timeoutElapsedMs += run.AvgTimeoutElapsedMs * float64(run.TimeoutErrors)
+	}
+	if aggregated.TimeoutErrors > 0 {
+		aggregated.AvgTimeoutElapsedMs = RoundToPrecision(timeoutElapsedMs / float64(aggregated.TimeoutErrors))
+	}
+
+	// Run-to-run spread of the generation speed
+	aggregated.GenerationSpeedStdDev = calculateStdDev(generationSpeeds, aggregated.GenerationSpeed)
+	if aggregated.GenerationSpeed > 0 {
+		aggregated.RunToRunCV = roundRatio(aggregated.GenerationSpeedStdDev / aggregated.GenerationSpeed)
+	}
+
+	// Run-to-run spread of the tail latency itself
+	aggregated.P95TtftStdDev = calculateStdDev(p95Ttfts, aggregated.P95Ttft)
+	if aggregated.P95Ttft > 0 {
+		aggregated.P95TtftUnstable = aggregated.P95TtftStdDev/aggregated.P95Ttft > HighP95TtftCV
+	}
+
+	aggregated.GenerationSpeed = RoundToPrecision(aggregated.GenerationSpeed)
+	aggregated.NormalizedTokenThroughput = RoundToPrecision(aggregated.NormalizedTokenThroughput)
+	aggregated.NormalizedGenerationSpeed = NormalizedGenerationSpeed(aggregated.GenerationSpeed, aggregated.AvgPromptTokens)
+	aggregated.GenerationSpeedStdDev = RoundToPrecision(aggregated.GenerationSpeedStdDev)
+	aggregated.PromptThroughput = RoundToPrecision(aggregated.PromptThroughput)
+	aggregated.PrefillSpeed = RoundToPrecision(aggregated.PrefillSpeed)
+	aggregated.TotalThroughput = RoundToPrecision(aggregated.TotalThroughput)
+	aggregated.MaxTtft = RoundToPrecision(aggregated.MaxTtft)
+	aggregated.MinTtft = RoundToPrecision(aggregated.MinTtft)
+	aggregated.AvgTtft = RoundToPrecision(aggregated.AvgTtft)
+	aggregated.MedianTtft = RoundToPrecision(aggregated.MedianTtft)
+	aggregated.P75Ttft = RoundToPrecision(aggregated.P75Ttft)
+	aggregated.P95Ttft = RoundToPrecision(aggregated.P95Ttft)
+	aggregated.P95TtftStdDev = RoundToPrecision(aggregated.P95TtftStdDev)
+	aggregated.P99Ttft = RoundToPrecision(aggregated.P99Ttft)
+	aggregated.StdDevTtft = RoundToPrecision(aggregated.StdDevTtft)
+	aggregated.Duration = RoundToPrecision(aggregated.Duration)
+	aggregated.GCPauseMs = RoundToPrecision(aggregated.GCPauseMs)
+	aggregated.BurstAvgTtft = RoundToPrecision(aggregated.BurstAvgTtft)
+	aggregated.SwitchAvgTtft = RoundToPrecision(aggregated.SwitchAvgTtft)
+	aggregated.SameModelAvgTtft = RoundToPrecision(aggregated.SameModelAvgTtft)
+	aggregated.ModelSwitchPenalty = RoundToPrecision(aggregated.ModelSwitchPenalty)
+	aggregated.ConnectionSetupMs = RoundToPrecision(aggregated.ConnectionSetupMs)
+	aggregated.AvgRequestBytes = RoundToPrecision(aggregated.AvgRequestBytes)
+	aggregated.AvgResponseBytes = RoundToPrecision(aggregated.AvgResponseBytes)
+	aggregated.ColdTtft = RoundToPrecision(aggregated.ColdTtft)
+	aggregated.CompletionLengthStdDev = RoundToPrecision(aggregated.CompletionLengthStdDev)
+	aggregated.DuplicateResponseRate = roundRatio(aggregated.DuplicateResponseRate)
+	aggregated.PromptTokensStdDev = RoundToPrecision(aggregated.PromptTokensStdDev)
+	aggregated.WarmTtft = RoundToPrecision(aggregated.WarmTtft)
+	aggregated.BurstP95Ttft = RoundToPrecision(aggregated.BurstP95Ttft)
+	aggregated.SustainedAvgTtft = RoundToPrecision(aggregated.SustainedAvgTtft)
+	aggregated.SustainedP95Ttft = RoundToPrecision(aggregated.SustainedP95Ttft)
+	aggregated.NewConnAvgTtft = RoundToPrecision(aggregated.NewConnAvgTtft)
+	aggregated.NewConnP95Ttft = RoundToPrecision(aggregated.NewConnP95Ttft)
+	aggregated.ReusedConnAvgTtft = RoundToPrecision(aggregated.ReusedConnAvgTtft)
+	aggregated.ReusedConnP95Ttft = RoundToPrecision(aggregated.ReusedConnP95Ttft)
+
+	aggregated.Regions = aggregateRegions(runs)
+	if len(aggregated.Regions) == 0 {
+		aggregated.Regions = nil
+	}
+
+	// Endpoints of split levels are aggregated pairwise, in the order they were measured
+	for e := range runs[0].Endpoints {
+		endpointRuns := make([]SpeedResult, 0, len(runs))
+		for _, run := range runs {
+			if e < len(run.Endpoints) {
+				endpointRuns = append(endpointRuns, run.Endpoints[e])
+			}
+		}
+		aggregated.Endpoints = append(aggregated.Endpoints, AggregateResults(endpointRuns))
+	}
+	if len(runs[0].InterleavedResults) > 0 {
+		var interleavedRuns []SpeedResult
+		for _, run := range runs {
+			interleavedRuns = append(interleavedRuns, run.InterleavedResults...)
+		}
+		aggregated.InterleavedResults = []SpeedResult{AggregateResults(interleavedRuns)}
+	}
+
+	return aggregated
+}
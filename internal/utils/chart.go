@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ASCIIBarChart renders one horizontal bar of █ characters per value, scaled so that the largest
+// value spans width characters. Each bar is labeled and followed by its value.
+func ASCIIBarChart(labels []string, values []float64, width int) string {
+	var largest float64
+	labelWidth := 0
+	for i, value := range values {
+		largest = max(largest, value)
+		if i < len(labels) {
+			labelWidth = max(labelWidth, len(labels[i]))
+		}
+	}
+
+	var chart strings.Builder
+	for i, value := range values {
+		label := ""
+		if i < len(labels) {
+			label = labels[i]
+		}
+		bar := 0
+		if largest > 0 && value > 0 {
+			bar = int(value / largest * float64(width))
+		}
+		fmt.Fprintf(&chart, "%*s | %s %.2f\n", labelWidth, label, strings.Repeat("█", bar), value)
+	}
+	return chart.String()
+}
@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"os"
+
+	"golang.org/x/term"
+)
+
+const (
+	colorReset  = "\033[0m"
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+)
+
+// colorEnabled defaults to on for terminals, unless NO_COLOR is set (https://no-color.org).
+var colorEnabled = os.Getenv("NO_COLOR") == "" && term.IsTerminal(int(os.Stdout.Fd()))
+
+// SetColor overrides the automatic color detection.
+func SetColor(enabled bool) {
+	colorEnabled = enabled
+}
+
+func colorize(color string, s string) string {
+	if !colorEnabled {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// Red marks failures and regressions.
+func Red(s string) string {
+	return colorize(colorRed, s)
+}
+
+// Green marks good results.
+func Green(s string) string {
+	return colorize(colorGreen, s)
+}
+
+// Yellow marks warnings.
+func Yellow(s string) string {
+	return colorize(colorYellow, s)
+}
@@ -11,7 +11,7 @@ import (
 func ParseConcurrencyLevels(concurrencyStr string) ([]int, error) {
 	// Split string
 	strLevels := strings.Split(concurrencyStr, ",")
-	
+
 	// Convert to integers
 	concurrencyLevels := make([]int, 0, len(strLevels))
 	for _, levelStr := range strLevels {
@@ -30,4 +30,53 @@ func ParseConcurrencyLevels(concurrencyStr string) ([]int, error) {
 	return concurrencyLevels, nil
 }
 
+// GenerateConcurrencyLevels generates concurrency levels up to max. step is "double" (1, 2, 4, ...),
+// "linear" (1, 2, 3, ...) or a number N (N, 2N, 3N, ...). max is always the last level.
+func GenerateConcurrencyLevels(max int, step string) ([]int, error) {
+	if max <= 0 {
+		return nil, errors.New("maximum concurrency must be positive: " + strconv.Itoa(max))
+	}
 
+	var next func(level int) int
+	start := 1
+	switch step {
+	case "double":
+		next = func(level int) int { return level * 2 }
+	case "linear":
+		next = func(level int) int { return level + 1 }
+	default:
+		increment, err := strconv.Atoi(step)
+		if err != nil || increment <= 0 {
+			return nil, errors.New("invalid concurrency step, expected 'double', 'linear' or a positive number: " + step)
+		}
+		start = increment
+		next = func(level int) int { return level + increment }
+	}
+
+	var concurrencyLevels []int
+	for level := start; level < max; level = next(level) {
+		concurrencyLevels = append(concurrencyLevels, level)
+	}
+	return append(concurrencyLevels, max), nil
+}
+
+// CapConcurrencyLevels clamps every level above limit down to limit and drops the resulting duplicates,
+// wherever they are in the list, keeping the order of their first occurrence. It reports whether any
+// level had to be clamped.
+func CapConcurrencyLevels(concurrencyLevels []int, limit int) ([]int, bool) {
+	clamped := false
+	seen := make(map[int]bool, len(concurrencyLevels))
+	capped := make([]int, 0, len(concurrencyLevels))
+	for _, level := range concurrencyLevels {
+		if level > limit {
+			level = limit
+			clamped = true
+		}
+		if seen[level] {
+			continue
+		}
+		seen[level] = true
+		capped = append(capped, level)
+	}
+	return capped, clamped
+}
@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCapConcurrencyLevels(t *testing.T) {
+	tests := []struct {
+		name    string
+		levels  []int
+		limit   int
+		want    []int
+		clamped bool
+	}{
+		{name: "below the cap", levels: []int{1, 2, 4}, limit: 8, want: []int{1, 2, 4}},
+		{name: "sorted", levels: []int{512, 1024, 2048, 4096}, limit: 1024, want: []int{512, 1024}, clamped: true},
+		{name: "unsorted plan", levels: []int{2048, 8, 4096}, limit: 1024, want: []int{1024, 8}, clamped: true},
+		{name: "descending", levels: []int{4096, 2048, 16}, limit: 1024, want: []int{1024, 16}, clamped: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, clamped := CapConcurrencyLevels(tt.levels, tt.limit)
+			if !slices.Equal(got, tt.want) || clamped != tt.clamped {
+				t.Errorf("CapConcurrencyLevels(%v, %d) = %v, %v, want %v, %v", tt.levels, tt.limit, got, clamped, tt.want, tt.clamped)
+			}
+		})
+	}
+}
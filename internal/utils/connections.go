@@ -0,0 +1,159 @@
+package utils
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// traceTransport records, for every request that had to dial a new connection,
+// the time from the start of the dial until the first response byte. It also counts
+// request and response body bytes and how many connections were newly dialed or reused.
+type traceTransport struct {
+	Base http.RoundTripper
+
+	mu      sync.Mutex
+	setupMs []float64
+
+	newConns      atomic.Int64
+	reusedConns   atomic.Int64
+	requests      atomic.Int64
+	requestBytes  atomic.Int64
+	responseBytes atomic.Int64
+}
+
+// countingReader adds the number of bytes read to a counter.
+type countingReader struct {
+	io.ReadCloser
+	counter *atomic.Int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.counter.Add(int64(n))
+	return n, err
+}
+
+func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var mu sync.Mutex
+	var dialStart, firstByte time.Time
+
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			if dialStart.IsZero() {
+				dialStart = time.Now()
+			}
+		},
+		ConnectStart: func(string, string) {
+			mu.Lock()
+			defer mu.Unlock()
+			if dialStart.IsZero() {
+				dialStart = time.Now()
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				t.reusedConns.Add(1)
+			} else {
+				t.newConns.Add(1)
+			}
+		},
+		GotFirstResponseByte: func() {
+			mu.Lock()
+			defer mu.Unlock()
+			firstByte = time.Now()
+		},
+	}
+
+	t.requests.Add(1)
+	if req.ContentLength > 0 {
+		t.requestBytes.Add(req.ContentLength)
+	}
+
+	resp, err := t.Base.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	if err == nil {
+		resp.Body = &countingReader{ReadCloser: resp.Body, counter: &t.responseBytes}
+	}
+
+	mu.Lock()
+	if !dialStart.IsZero() && firstByte.After(dialStart) {
+		t.mu.Lock()
+		t.setupMs = append(t.setupMs, float64(firstByte.Sub(dialStart).Microseconds())/1000)
+		t.mu.Unlock()
+	}
+	mu.Unlock()
+
+	return resp, err
+}
+
+// reset discards everything recorded so far, e.g. after warm-up requests.
+func (t *traceTransport) reset() {
+	t.mu.Lock()
+	t.setupMs = nil
+	t.mu.Unlock()
+	t.newConns.Store(0)
+	t.reusedConns.Store(0)
+	t.requests.Store(0)
+	t.requestBytes.Store(0)
+	t.responseBytes.Store(0)
+}
+
+// avgBytes returns the mean request and response body size per request.
+func (t *traceTransport) avgBytes() (float64, float64) {
+	requests := t.requests.Load()
+	if requests == 0 {
+		return 0, 0
+	}
+	return float64(t.requestBytes.Load()) / float64(requests), float64(t.responseBytes.Load()) / float64(requests)
+}
+
+// connections returns the number of newly dialed and of reused connections.
+func (t *traceTransport) connections() (int, int) {
+	return int(t.newConns.Load()), int(t.reusedConns.Load())
+}
+
+// avgSetupMs returns the mean connection setup time over all dialed requests.
+func (t *traceTransport) avgSetupMs() float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return calculateMean(t.setupMs)
+}
+
+// prewarmConnections opens n connections to baseURL in parallel so that they sit idle in the
+// transport's pool when timing starts. HEAD is tried first, OPTIONS when HEAD is not allowed.
+// It returns the number of requests that got a response.
+func prewarmConnections(client *http.Client, baseURL string, n int) int {
+	var wg sync.WaitGroup
+	var warmed atomic.Int32
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, method := range []string{http.MethodHead, http.MethodOptions} {
+				req, err := http.NewRequest(method, baseURL, nil)
+				if err != nil {
+					return
+				}
+				resp, err := client.Do(req)
+				if err != nil {
+					return
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusMethodNotAllowed && resp.StatusCode != http.StatusNotImplemented {
+					warmed.Add(1)
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	return int(warmed.Load())
+}
@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// CSVHeader returns the CSV column names of SpeedResult, taken from its JSON field names.
+// Nested results such as the per-endpoint ones have no column, CSV rows stay flat.
+func CSVHeader() []string {
+	t := reflect.TypeOf(SpeedResult{})
+	var header []string
+	for _, i := range csvFields(t) {
+		header = append(header, csvName(t.Field(i)))
+	}
+	return header
+}
+
+// csvFields returns the indices of the fields of t that are written to CSV.
+func csvFields(t reflect.Type) []int {
+	var fields []int
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Type.Kind() != reflect.Slice {
+			fields = append(fields, i)
+		}
+	}
+	return fields
+}
+
+func csvName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// MarshalCSV returns the values of the result in the order of CSVHeader.
+func (result SpeedResult) MarshalCSV() ([]string, error) {
+	v := reflect.ValueOf(result)
+	fields := csvFields(v.Type())
+	record := make([]string, len(fields))
+	for j, i := range fields {
+		field := v.Field(i)
+		switch field.Kind() {
+		case reflect.Float64:
+			record[j] = strconv.FormatFloat(field.Float(), 'f', -1, 64)
+		case reflect.Int:
+			record[j] = strconv.FormatInt(field.Int(), 10)
+		case reflect.Uint32, reflect.Uint64:
+			record[j] = strconv.FormatUint(field.Uint(), 10)
+		case reflect.Bool:
+			record[j] = strconv.FormatBool(field.Bool())
+		case reflect.String:
+			record[j] = field.String()
+		default:
+			return nil, fmt.Errorf("unsupported CSV field %s of kind %s", v.Type().Field(i).Name, field.Kind())
+		}
+	}
+	return record, nil
+}
+
+// UnmarshalCSV sets the result from a record in the order of CSVHeader.
+func (result *SpeedResult) UnmarshalCSV(record []string) error {
+	v := reflect.ValueOf(result).Elem()
+	fields := csvFields(v.Type())
+	if len(record) != len(fields) {
+		return fmt.Errorf("expected %d CSV fields, got %d", len(fields), len(record))
+	}
+	for j, value := range record {
+		i := fields[j]
+		field := v.Field(i)
+		name := csvName(v.Type().Field(i))
+		switch field.Kind() {
+		case reflect.Float64:
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			field.SetFloat(parsed)
+		case reflect.Int:
+			parsed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			field.SetInt(parsed)
+		case reflect.Uint32, reflect.Uint64:
+			parsed, err := strconv.ParseUint(value, 10, field.Type().Bits())
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			field.SetUint(parsed)
+		case reflect.Bool:
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			field.SetBool(parsed)
+		case reflect.String:
+			field.SetString(value)
+		default:
+			return fmt.Errorf("unsupported CSV field %s of kind %s", name, field.Kind())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSpeedResultCSVRoundTrip(t *testing.T) {
+	known := SpeedResult{
+		Concurrency:           4,
+		GenerationSpeed:       123.45,
+		PromptThroughput:      678.9,
+		AvgTtft:               0.25,
+		P95Ttft:               0.5,
+		SuccessRate:           0.75,
+		SuccessfulRequests:    3,
+		FailedRequests:        1,
+		TotalCompletionTokens: 1536,
+		P95TtftUnstable:       true,
+		TotalAllocBytes:       1 << 40,
+		NumGC:                 7,
+		MinRemainingRateLimit: -1,
+		QuotaError:            "insufficient_quota",
+	}
+
+	// Every scalar field set, so that a field added with an unsupported type fails here
+	full := SpeedResult{}
+	v := reflect.ValueOf(&full).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		switch field.Kind() {
+		case reflect.Float64:
+			field.SetFloat(float64(i) + 0.125)
+		case reflect.Int:
+			field.SetInt(int64(i) - 3)
+		case reflect.Uint32, reflect.Uint64:
+			field.SetUint(uint64(i) + 1)
+		case reflect.Bool:
+			field.SetBool(true)
+		case reflect.String:
+			field.SetString("value, with a comma")
+		}
+	}
+
+	for name, want := range map[string]SpeedResult{"known": known, "all fields": full} {
+		t.Run(name, func(t *testing.T) {
+			record, err := want.MarshalCSV()
+			if err != nil {
+				t.Fatalf("MarshalCSV: %v", err)
+			}
+			if len(record) != len(CSVHeader()) {
+				t.Fatalf("got %d values for %d columns", len(record), len(CSVHeader()))
+			}
+			var got SpeedResult
+			if err := got.UnmarshalCSV(record); err != nil {
+				t.Fatalf("UnmarshalCSV: %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("round trip changed the result\ngot:  %+v\nwant: %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestSpeedResultUnmarshalCSVErrors(t *testing.T) {
+	record, err := SpeedResult{}.MarshalCSV()
+	if err != nil {
+		t.Fatalf("MarshalCSV: %v", err)
+	}
+
+	var result SpeedResult
+	if err := result.UnmarshalCSV(record[1:]); err == nil {
+		t.Error("expected an error for a record with a missing field")
+	}
+
+	record[0] = "four"
+	if err := result.UnmarshalCSV(record); err == nil {
+		t.Error("expected an error for a non-numeric concurrency")
+	}
+}
@@ -0,0 +1,62 @@
+package utils
+
+import "sort"
+
+// ttftSpikeFactor is how many times the median TTFT of a level a request has to take to count as a spike.
+const ttftSpikeFactor = 2
+
+// interleaved reports whether request i of a level goes to InterleaveModel instead of ModelName.
+// The models alternate request by request, so both get half of the level.
+func (setup *SpeedMeasurement) interleaved(i int) bool {
+	return setup.InterleaveModel != "" && i%2 == 1
+}
+
+// summarizeModelSwitches sets the model switch metrics of measurement from all requests of the level,
+// interleaved marks the ones sent to InterleaveModel. All requests of a level are sent at once, so the
+// order the server processed them in is taken from the arrival of their first tokens: a request is a
+// switch if the first token that arrived before its own belonged to the other model. A server that
+// evicts and reloads a model on a switch shows up as a positive ModelSwitchPenalty and spikes
+// concentrated on switches.
+func (outcomes *requestOutcomes) summarizeModelSwitches(measurement *SpeedResult, interleaved []bool) {
+	var order []int
+	var ttfts []float64
+	for i, ok := range outcomes.succeeded {
+		if ok {
+			order = append(order, i)
+			ttfts = append(ttfts, outcomes.ttfts[i])
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return outcomes.firstTokens[order[a]] < outcomes.firstTokens[order[b]]
+	})
+
+	// The first request to produce a token follows no other one and is in neither group
+	switched := make([]bool, len(outcomes.succeeded))
+	var switchTtfts, sameTtfts []float64
+	for k := 1; k < len(order); k++ {
+		i := order[k]
+		if interleaved[i] != interleaved[order[k-1]] {
+			switched[i] = true
+			measurement.ModelSwitches++
+			switchTtfts = append(switchTtfts, outcomes.ttfts[i])
+		} else {
+			sameTtfts = append(sameTtfts, outcomes.ttfts[i])
+		}
+	}
+
+	measurement.SwitchAvgTtft = RoundToPrecision(calculateMean(switchTtfts))
+	measurement.SameModelAvgTtft = RoundToPrecision(calculateMean(sameTtfts))
+	if len(switchTtfts) > 0 && len(sameTtfts) > 0 {
+		measurement.ModelSwitchPenalty = RoundToPrecision(measurement.SwitchAvgTtft - measurement.SameModelAvgTtft)
+	}
+
+	threshold := calculatePercentile(ttfts, 0.5) * ttftSpikeFactor
+	for _, i := range order {
+		if threshold > 0 && outcomes.ttfts[i] > threshold {
+			measurement.TtftSpikes++
+			if switched[i] {
+				measurement.SwitchTtftSpikes++
+			}
+		}
+	}
+}
@@ -7,26 +7,55 @@ import (
 	"time"
 )
 
+// LatencyMeasurement is the distribution of the network latency to the API host, in milliseconds.
+type LatencyMeasurement struct {
+	Avg     float64
+	Min     float64
+	Max     float64
+	P95     float64
+	StdDev  float64
+	Samples int
+}
+
 // MeasureLatency tests the network latency to a given base URL.
 func MeasureLatency(baseURL string, attempts int) (float64, error) {
+	latency, err := MeasureLatencyDetailed(baseURL, attempts)
+	return latency.Avg, err
+}
+
+// MeasureLatencyDetailed tests the network latency to a given base URL and returns its distribution.
+func MeasureLatencyDetailed(baseURL string, attempts int) (LatencyMeasurement, error) {
 	if baseURL == "" {
-		return 0, fmt.Errorf("empty base URL")
+		return LatencyMeasurement{}, fmt.Errorf("empty base URL")
 	}
 
 	parsedURL, err := url.Parse(baseURL)
 	if err != nil {
-		return 0, fmt.Errorf("invalid base URL: %w", err)
+		return LatencyMeasurement{}, fmt.Errorf("invalid base URL: %w", err)
 	}
 
-	var totalLatency float64
+	samples := make([]float64, 0, attempts)
 	for i := 0; i < attempts; i++ {
 		start := time.Now()
 		conn, err := http.Get(parsedURL.Scheme + "://" + parsedURL.Host)
 		if err != nil {
-			return 0, fmt.Errorf("HTTP GET error: %w", err)
+			return LatencyMeasurement{}, fmt.Errorf("HTTP GET error: %w", err)
 		}
 		conn.Body.Close()
-		totalLatency += float64(time.Since(start).Milliseconds())
+		samples = append(samples, float64(time.Since(start).Milliseconds()))
+	}
+
+	latency := LatencyMeasurement{Samples: len(samples)}
+	if len(samples) == 0 {
+		return latency, nil
+	}
+	latency.Avg = calculateMean(samples)
+	latency.Min, latency.Max = samples[0], samples[0]
+	for _, sample := range samples {
+		latency.Min = min(latency.Min, sample)
+		latency.Max = max(latency.Max, sample)
 	}
-	return totalLatency / float64(attempts), nil
+	latency.P95 = calculatePercentile(samples, 0.95)
+	latency.StdDev = calculateStdDev(samples, latency.Avg)
+	return latency, nil
 }
@@ -1,56 +1,238 @@
 package utils
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
+
+	"go.yaml.in/yaml/v4"
 )
 
+// DefaultReportTitle is the title of the banner and of reports without a custom one.
+const DefaultReportTitle = "LLM API Throughput Benchmark"
+
+// bannerCenter is the column the banner lines are centered on.
+const bannerCenter = 72
+
 // PrintBenchmarkHeader prints the benchmark header with details about the test.
-func PrintBenchmarkHeader(modelName string, inputTokens int, maxTokens int, latency float64) {
+func PrintBenchmarkHeader(modelName string, inputTokens int, maxTokens int, latency LatencyMeasurement) {
+	FprintBenchmarkHeader(os.Stdout, DefaultReportTitle, modelName, inputTokens, maxTokens, latency)
+}
+
+// FprintBenchmarkHeader writes the benchmark header to w, with title centered in the banner.
+func FprintBenchmarkHeader(w io.Writer, title string, modelName string, inputTokens int, maxTokens int, latency LatencyMeasurement) {
 	banner :=
 		`
 ##############################################################################################################################################
-                                                          LLM API Throughput Benchmark
+%s
                                                     https://github.com/Yoosu-L/llmapibenchmark
                                                           Time：%s
 ##############################################################################################################################################`
 
-	fmt.Printf(banner+"\n", time.Now().UTC().Format("2006-01-02 15:04:05 UTC+0"))
-	fmt.Printf("Input Tokens: %d\n", inputTokens)
-	fmt.Printf("Output Tokens: %d\n", maxTokens)
-	fmt.Printf("Test Model: %s\n", modelName)
-	fmt.Printf("Latency: %.2f ms\n\n", latency)
+	centeredTitle := strings.Repeat(" ", max(0, bannerCenter-len(title)/2)) + title
+	fmt.Fprintf(w, banner+"\n", centeredTitle, time.Now().UTC().Format("2006-01-02 15:04:05 UTC+0"))
+	fmt.Fprintf(w, "Input Tokens: %d\n", inputTokens)
+	fmt.Fprintf(w, "Output Tokens: %s\n", FormatMaxTokens(maxTokens))
+	fmt.Fprintf(w, "Test Model: %s\n", modelName)
+	fmt.Fprintf(w, "Latency: %.2f ms (P95 %.2f ms, StdDev %.2f ms, %d samples)\n\n", latency.Avg, latency.P95, latency.StdDev, latency.Samples)
+}
+
+// SaveResultsToJSON writes result as indented JSON to path.
+func SaveResultsToJSON(result interface{}, path string) error {
+	data, err := json.MarshalIndent(result, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error marshalling JSON: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing %s: %w", path, err)
+	}
+	return nil
+}
+
+// SaveResultsToYAML writes result as YAML to path.
+func SaveResultsToYAML(result interface{}, path string) error {
+	data, err := yaml.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("error marshalling YAML: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("error writing %s: %w", path, err)
+	}
+	return nil
 }
 
-// SaveResultsToMD saves the benchmark results to a Markdown file.
-func SaveResultsToMD(results [][]interface{}, modelName string, inputTokens int, maxTokens int, latency float64) {
-	// sanitize modelName to create a safe filename (replace path separators)
+// SafeModelName turns a model name into something usable in a file name (path separators are replaced).
+func SafeModelName(modelName string) string {
 	safeModelName := strings.ReplaceAll(modelName, "/", "_")
 	safeModelName = strings.ReplaceAll(safeModelName, "\\", "_")
 	safeModelName = strings.TrimSpace(safeModelName)
 	if safeModelName == "" {
 		safeModelName = "model"
 	}
-	filename := fmt.Sprintf("API_Throughput_%s.md", safeModelName)
+	return safeModelName
+}
+
+// MarkdownOptions controls the rendering of the Markdown results table.
+type MarkdownOptions struct {
+	Highlight    bool   // Bold the fastest row and strike through the least reliable one
+	ShortHeaders bool   // Leave the units out of the headers
+	Chart        bool   // Add a bar chart of the generation speed per concurrency level
+	Dir          string // Directory of the file, the working directory if empty
+	Title        string // H1 of the file, none if empty
+	Description  string // Paragraph printed before the table
+}
+
+// markdownColumns are the headers of the Markdown table and their units.
+var markdownColumns = [][2]string{
+	{"C", ""},
+	{"Gen Speed", "tok/s"},
+	{"Prompt TP", "tok/s"},
+	{"Total TP", "tok/s"},
+	{"Min TTFT", "s"},
+	{"Avg TTFT", "s"},
+	{"Med TTFT", "s"},
+	{"P95 TTFT", "s"},
+	{"P99 TTFT", "s"},
+	{"StdDev", "s"},
+	{"Success", ""},
+	{"Reqs", ""},
+	{"Duration", "s"},
+}
+
+// markdownHeader returns the header and separator lines of the Markdown table.
+func markdownHeader(short bool) (string, string) {
+	headers := make([]string, len(markdownColumns))
+	separators := make([]string, len(markdownColumns))
+	for i, column := range markdownColumns {
+		headers[i] = column[0]
+		if !short && column[1] != "" {
+			headers[i] = fmt.Sprintf("%s (%s)", column[0], column[1])
+		}
+		separators[i] = strings.Repeat("-", len(headers[i])+2)
+	}
+	return "| " + strings.Join(headers, " | ") + " |", "|" + strings.Join(separators, "|") + "|"
+}
+
+// FormatMaxTokens formats the max-tokens setting of a report, 0 means no limit was sent.
+func FormatMaxTokens(maxTokens int) string {
+	if maxTokens == 0 {
+		return "unlimited"
+	}
+	return strconv.Itoa(maxTokens)
+}
+
+// SaveResultsToMD saves the benchmark results to a Markdown file and returns its name, or "" if it could not be created.
+// The summary row, if not nil, is written below the results after a separator line.
+// With options.Highlight set, the row with the highest generation speed is bold and the row with the
+// lowest success rate (if below 100%) is struck through.
+func SaveResultsToMD(results [][]interface{}, summary []interface{}, modelName string, inputTokens int, maxTokens int, latency float64, options MarkdownOptions) string {
+	return writeResultsToMD(false, results, summary, modelName, inputTokens, maxTokens, latency, options)
+}
+
+// AppendResultsToMD works like SaveResultsToMD, but adds the results as a new timestamped section
+// to the end of the file instead of overwriting it.
+func AppendResultsToMD(results [][]interface{}, summary []interface{}, modelName string, inputTokens int, maxTokens int, latency float64, options MarkdownOptions) string {
+	return writeResultsToMD(true, results, summary, modelName, inputTokens, maxTokens, latency, options)
+}
+
+// SaveWideResultsToMD saves every scalar SpeedResult field as a column, in the order of CSVHeader, to
+// API_Throughput_<model>_wide.md and returns its name, or "" if it could not be created. The table is
+// meant for spreadsheets and viewers that scroll horizontally, not for GitHub's renderer.
+func SaveWideResultsToMD(results []SpeedResult, modelName string, inputTokens int, maxTokens int, latency float64, options MarkdownOptions) string {
+	filename := filepath.Join(options.Dir, fmt.Sprintf("API_Throughput_%s_wide.md", SafeModelName(modelName)))
 	file, err := os.Create(filename)
 	if err != nil {
 		log.Printf("Error creating file: %v", err)
-		return
+		return ""
 	}
 	defer file.Close()
 
+	if options.Title != "" {
+		file.WriteString(fmt.Sprintf("# %s\n\n", options.Title))
+	}
 	file.WriteString(fmt.Sprintf("```\nInput Tokens: %d\n", inputTokens))
-	file.WriteString(fmt.Sprintf("Output Tokens: %d\n", maxTokens))
+	file.WriteString(fmt.Sprintf("Output Tokens: %s\n", FormatMaxTokens(maxTokens)))
 	file.WriteString(fmt.Sprintf("Test Model: %s\n", modelName))
 	file.WriteString(fmt.Sprintf("Latency: %.2f ms\n```\n\n", latency))
-	file.WriteString("| C | Gen Speed | Prompt TP | Total TP | Min TTFT | Avg TTFT | Med TTFT | P95 TTFT | P99 TTFT | StdDev | Success | Reqs | Duration |\n")
-	file.WriteString("|---|-----------|-----------|----------|----------|----------|----------|----------|----------|--------|-------|------|----------|\n")
 
+	header := CSVHeader()
+	file.WriteString("| " + strings.Join(header, " | ") + " |\n")
+	file.WriteString("|" + strings.Repeat("---:|", len(header)) + "\n")
 	for _, result := range results {
-		concurrency := result[0].(int)
+		record, err := result.MarshalCSV()
+		if err != nil {
+			log.Printf("Error writing wide table: %v", err)
+			return ""
+		}
+		file.WriteString("| " + strings.Join(record, " | ") + " |\n")
+	}
+	return filename
+}
+
+func writeResultsToMD(appendSection bool, results [][]interface{}, summary []interface{}, modelName string, inputTokens int, maxTokens int, latency float64, options MarkdownOptions) string {
+	filename := filepath.Join(options.Dir, fmt.Sprintf("API_Throughput_%s.md", SafeModelName(modelName)))
+	flag := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	newFile := true
+	if appendSection {
+		if info, err := os.Stat(filename); err == nil && info.Size() > 0 {
+			newFile = false
+		}
+		flag = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	file, err := os.OpenFile(filename, flag, 0644)
+	if err != nil {
+		log.Printf("Error creating file: %v", err)
+		return ""
+	}
+	defer file.Close()
+
+	if newFile && options.Title != "" {
+		file.WriteString(fmt.Sprintf("# %s\n\n", options.Title))
+	}
+	if appendSection {
+		file.WriteString(fmt.Sprintf("\n## %s\n\n", time.Now().UTC().Format("2006-01-02 15:04:05 UTC+0")))
+	}
+
+	file.WriteString(fmt.Sprintf("```\nInput Tokens: %d\n", inputTokens))
+	file.WriteString(fmt.Sprintf("Output Tokens: %s\n", FormatMaxTokens(maxTokens)))
+	file.WriteString(fmt.Sprintf("Test Model: %s\n", modelName))
+	file.WriteString(fmt.Sprintf("Latency: %.2f ms\n```\n\n", latency))
+	if options.Description != "" {
+		file.WriteString(options.Description + "\n\n")
+	}
+	header, separator := markdownHeader(options.ShortHeaders)
+	file.WriteString(header + "\n")
+	file.WriteString(separator + "\n")
+
+	// Find the best and worst rows before rendering
+	best, worst := -1, -1
+	if options.Highlight {
+		for i, result := range results {
+			if best < 0 || result[1].(float64) > results[best][1].(float64) {
+				best = i
+			}
+			if successRate := result[10].(float64); successRate < 1 && (worst < 0 || successRate < results[worst][10].(float64)) {
+				worst = i
+			}
+		}
+	}
+
+	rows := results
+	if summary != nil {
+		rows = append(rows[:len(rows):len(rows)], nil, summary)
+	}
+	for i, result := range rows {
+		if result == nil {
+			file.WriteString(separator + "\n")
+			continue
+		}
+		concurrency := result[0] // The level, or a label for the summary row
 		generationSpeed := result[1].(float64)
 		promptThroughput := result[2].(float64)
 		totalThroughput := result[3].(float64)
@@ -63,22 +245,50 @@ func SaveResultsToMD(results [][]interface{}, modelName string, inputTokens int,
 		successRate := result[10].(float64)
 		successfulReqs := result[11].(int)
 		duration := result[12].(float64)
-		file.WriteString(fmt.Sprintf("| %2d | %9.2f | %9.2f | %8.2f | %8.2f | %8.2f | %8.2f | %8.2f | %8.2f | %6.2f | %5.2f%% | %4d | %8.2f |\n",
+		row := fmt.Sprintf("| %2v | %9s | %9s | %8s | %8s | %8s | %8s | %8s | %8s | %6s | %5s%% | %4d | %8s |",
 			concurrency,
-			generationSpeed,
-			promptThroughput,
-			totalThroughput,
-			minTTFT,
-			avgTTFT,
-			medianTTFT,
-			p95TTFT,
-			p99TTFT,
-			stdDevTTFT,
-			successRate*100,
+			FormatFloat(generationSpeed),
+			FormatFloat(promptThroughput),
+			FormatFloat(totalThroughput),
+			FormatFloat(minTTFT),
+			FormatFloat(avgTTFT),
+			FormatFloat(medianTTFT),
+			FormatFloat(p95TTFT),
+			FormatFloat(p99TTFT),
+			FormatFloat(stdDevTTFT),
+			FormatFloat(successRate*100),
 			successfulReqs,
-			duration,
-		))
+			FormatFloat(duration),
+		)
+		switch i {
+		case worst:
+			row = markRow(row, "~~")
+		case best:
+			row = markRow(row, "**")
+		}
+		file.WriteString(row + "\n")
+	}
+
+	if options.Chart && len(results) > 0 {
+		labels := make([]string, len(results))
+		values := make([]float64, len(results))
+		for i, result := range results {
+			labels[i] = fmt.Sprintf("C=%v", result[0])
+			values[i] = result[1].(float64)
+		}
+		file.WriteString("\nGeneration speed (tokens/s) by concurrency:\n\n```\n")
+		file.WriteString(ASCIIBarChart(labels, values, 50))
+		file.WriteString("```\n")
 	}
 
-	fmt.Printf("Results saved to: %s\n\n", filename)
+	return filename
+}
+
+// markRow wraps every cell of a Markdown table row in marker, e.g. ** for bold.
+func markRow(row string, marker string) string {
+	cells := strings.Split(strings.Trim(row, "| "), " | ")
+	for i, cell := range cells {
+		cells[i] = marker + strings.TrimSpace(cell) + marker
+	}
+	return "| " + strings.Join(cells, " | ") + " |"
 }
@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Response headers in which servers and gateways in front of vLLM-style schedulers expose their state
+// when the request was admitted. The first header of each list that is present is used.
+var (
+	queueDepthHeaders      = []string{"X-Queue-Depth", "X-Num-Requests-Waiting", "X-Requests-Waiting"}
+	runningRequestsHeaders = []string{"X-Num-Requests-Running", "X-Requests-Running"}
+	kvCacheUsageHeaders    = []string{"X-Kv-Cache-Usage", "X-Gpu-Cache-Usage"}
+)
+
+// queueState is the scheduler state a response reported, -1 for values it did not send.
+type queueState struct {
+	depth        float64 // Requests waiting to be scheduled
+	running      float64 // Requests being processed
+	kvCacheUsage float64 // Share of the KV cache in use, 0 to 1
+}
+
+// queueStateOf reads the scheduler state from a response header, nil if none of the headers was sent.
+func queueStateOf(header http.Header) *queueState {
+	depth, _ := headerNumber(header, queueDepthHeaders)
+	running, _ := headerNumber(header, runningRequestsHeaders)
+	kvCacheUsage, percent := headerNumber(header, kvCacheUsageHeaders)
+	if depth < 0 && running < 0 && kvCacheUsage < 0 {
+		return nil
+	}
+	if percent || kvCacheUsage > 1 {
+		// Sent as a percentage, with a % suffix or without one but above 1
+		kvCacheUsage /= 100
+	}
+	return &queueState{depth: depth, running: running, kvCacheUsage: kvCacheUsage}
+}
+
+// headerNumber returns the value of the first of names present in header, -1 if none is present or valid.
+// percent reports whether the value had a % suffix.
+func headerNumber(header http.Header, names []string) (number float64, percent bool) {
+	for _, name := range names {
+		value, percent := strings.CutSuffix(strings.TrimSpace(header.Get(name)), "%")
+		if value == "" {
+			continue
+		}
+		if number, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && number >= 0 {
+			return number, percent
+		}
+	}
+	return -1, false
+}
+
+// summarizeQueue sets the queue metrics of measurement from the states reported by its requests.
+// Values no response reported are left at 0, only QueueSamples tells whether any were sent.
+func summarizeQueue(measurement *SpeedResult, states []*queueState) {
+	var depths, running, kvCacheUsage []float64
+	for _, state := range states {
+		if state == nil {
+			continue
+		}
+		measurement.QueueSamples++
+		if state.depth >= 0 {
+			depths = append(depths, state.depth)
+		}
+		if state.running >= 0 {
+			running = append(running, state.running)
+		}
+		if state.kvCacheUsage >= 0 {
+			kvCacheUsage = append(kvCacheUsage, state.kvCacheUsage)
+		}
+	}
+	measurement.AvgQueueDepth = RoundToPrecision(calculateMean(depths))
+	for _, depth := range depths {
+		measurement.MaxQueueDepth = max(measurement.MaxQueueDepth, depth)
+	}
+	measurement.AvgRunningRequests = RoundToPrecision(calculateMean(running))
+	measurement.AvgKVCacheUsage = roundRatio(calculateMean(kvCacheUsage))
+}
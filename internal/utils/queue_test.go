@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestQueueStateKVCacheUsage(t *testing.T) {
+	tests := []struct {
+		value string
+		want  float64
+	}{
+		{value: "0.5", want: 0.5},
+		{value: "1", want: 1},
+		{value: "1%", want: 0.01},
+		{value: "0.5%", want: 0.005},
+		{value: "42.5%", want: 0.425},
+		{value: "42.5", want: 0.425},
+		{value: "100 %", want: 1},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		header.Set("X-Gpu-Cache-Usage", tt.value)
+		state := queueStateOf(header)
+		if state == nil {
+			t.Errorf("%q: no queue state", tt.value)
+			continue
+		}
+		if diff := state.kvCacheUsage - tt.want; diff > 1e-9 || diff < -1e-9 {
+			t.Errorf("%q: kvCacheUsage = %v, want %v", tt.value, state.kvCacheUsage, tt.want)
+		}
+	}
+
+	if state := queueStateOf(http.Header{}); state != nil {
+		t.Errorf("no headers: queue state %+v, want nil", state)
+	}
+}
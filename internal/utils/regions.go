@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// UnknownRegion groups the requests whose response did not name a region.
+const UnknownRegion = "unknown"
+
+// RegionResult summarizes the successful requests served by one region, as named by the RegionHeader
+// response header. Providers routing to several regions may differ noticeably between them.
+type RegionResult struct {
+	Region   string  `json:"region" yaml:"region"`
+	Requests int     `json:"requests" yaml:"requests"`
+	Share    float64 `json:"share" yaml:"share"` // Share of the successful requests of the level
+	AvgTtft  float64 `json:"avg_ttft" yaml:"avg-ttft"`
+	P95Ttft  float64 `json:"p95_ttft" yaml:"p95-ttft"`
+}
+
+// regionOf returns the region a response header names. cf-ray values end in the code of the
+// Cloudflare data center, e.g. 8a1b2c3d4e5f6789-FRA, other headers are taken as they are.
+func regionOf(name string, header http.Header) string {
+	value := strings.TrimSpace(header.Get(name))
+	if value == "" {
+		return UnknownRegion
+	}
+	if strings.EqualFold(name, "cf-ray") {
+		if i := strings.LastIndex(value, "-"); i >= 0 && i < len(value)-1 {
+			return value[i+1:]
+		}
+	}
+	return value
+}
+
+// summarizeRegions groups the TTFTs of the given requests by region, the most used region first.
+func summarizeRegions(regions []string, ttfts []float64) []RegionResult {
+	byRegion := make(map[string][]float64)
+	for i, region := range regions {
+		byRegion[region] = append(byRegion[region], ttfts[i])
+	}
+
+	results := make([]RegionResult, 0, len(byRegion))
+	for region, values := range byRegion {
+		results = append(results, RegionResult{
+			Region:   region,
+			Requests: len(values),
+			Share:    roundRatio(float64(len(values)) / float64(len(regions))),
+			AvgTtft:  RoundToPrecision(calculateMean(values)),
+			P95Ttft:  RoundToPrecision(calculatePercentile(values, 0.95)),
+		})
+	}
+	sortRegions(results)
+	return results
+}
+
+// aggregateRegions merges the regions of repeated runs. TTFTs are weighted by the number of requests.
+func aggregateRegions(runs []SpeedResult) []RegionResult {
+	merged := make(map[string]*RegionResult)
+	total := 0
+	for _, run := range runs {
+		for _, region := range run.Regions {
+			result, ok := merged[region.Region]
+			if !ok {
+				result = &RegionResult{Region: region.Region}
+				merged[region.Region] = result
+			}
+			result.Requests += region.Requests
+			result.AvgTtft += region.AvgTtft * float64(region.Requests)
+			result.P95Ttft += region.P95Ttft * float64(region.Requests)
+			total += region.Requests
+		}
+	}
+
+	results := make([]RegionResult, 0, len(merged))
+	for _, result := range merged {
+		if result.Requests > 0 {
+			result.AvgTtft = RoundToPrecision(result.AvgTtft / float64(result.Requests))
+			result.P95Ttft = RoundToPrecision(result.P95Ttft / float64(result.Requests))
+			result.Share = roundRatio(float64(result.Requests) / float64(total))
+		}
+		results = append(results, *result)
+	}
+	sortRegions(results)
+	return results
+}
+
+func sortRegions(results []RegionResult) {
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Requests != results[j].Requests {
+			return results[i].Requests > results[j].Requests
+		}
+		return results[i].Region < results[j].Region
+	})
+}
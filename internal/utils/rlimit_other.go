@@ -0,0 +1,8 @@
+//go:build !unix
+
+package utils
+
+// FileDescriptorLimit is not available on this platform.
+func FileDescriptorLimit() (uint64, bool) {
+	return 0, false
+}
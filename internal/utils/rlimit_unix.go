@@ -0,0 +1,14 @@
+//go:build unix
+
+package utils
+
+import "syscall"
+
+// FileDescriptorLimit returns the soft limit on open file descriptors for this process.
+func FileDescriptorLimit() (uint64, bool) {
+	var rlimit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlimit); err != nil {
+		return 0, false
+	}
+	return uint64(rlimit.Cur), true
+}
@@ -1,9 +1,16 @@
 package utils
 
 import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
 	"math"
+	"math/rand"
 	"net/http"
+	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -20,12 +27,28 @@ type HeaderTransport struct {
 	Base      http.RoundTripper
 	Headers   map[string]string
 	AuthToken string
+
+	// InjectLatency is slept before forwarding a request, with probability InjectLatencyProbability.
+	InjectLatency            time.Duration
+	InjectLatencyProbability float64
+	injectedLatencyCount     atomic.Int32
+
+	// Lowest x-ratelimit-remaining-requests value seen in any response
+	rateLimitMu          sync.Mutex
+	rateLimitSeen        bool
+	minRemainingRequests int
 }
 
 func (t *HeaderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Simulate client-side network jitter
+	if t.InjectLatency > 0 && rand.Float64() < t.InjectLatencyProbability {
+		t.injectedLatencyCount.Add(1)
+		time.Sleep(t.InjectLatency)
+	}
+
 	// Clone the request to avoid modifying the original
 	newReq := req.Clone(req.Context())
-	
+
 	// Add custom headers
 	for key, value := range t.Headers {
 		// Replace {api_key} placeholder with actual API key
@@ -34,10 +57,63 @@ func (t *HeaderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 		}
 		newReq.Header.Set(key, value)
 	}
-	
-	return t.Base.RoundTrip(newReq)
+
+	resp, err := t.Base.RoundTrip(newReq)
+	if err == nil {
+		t.recordRateLimit(resp.Header)
+		api.RecordResponseHeader(req, resp)
+	}
+	return resp, err
+}
+
+// recordRateLimit tracks the x-ratelimit-remaining-requests header sent by OpenAI and Azure.
+// x-ratelimit-limit-requests and x-ratelimit-reset-requests only matter once it reaches 0.
+func (t *HeaderTransport) recordRateLimit(header http.Header) {
+	remaining, err := strconv.Atoi(header.Get("x-ratelimit-remaining-requests"))
+	if err != nil {
+		return
+	}
+
+	t.rateLimitMu.Lock()
+	defer t.rateLimitMu.Unlock()
+	if !t.rateLimitSeen || remaining < t.minRemainingRequests {
+		t.minRemainingRequests = remaining
+		t.rateLimitSeen = true
+		if remaining == 0 {
+			log.Printf("Warning: rate limit exhausted (limit %s requests, resets in %s)",
+				header.Get("x-ratelimit-limit-requests"), header.Get("x-ratelimit-reset-requests"))
+		}
+	}
 }
 
+// MinRemainingRequests returns the lowest remaining request budget reported by the server, if any.
+func (t *HeaderTransport) MinRemainingRequests() (int, bool) {
+	t.rateLimitMu.Lock()
+	defer t.rateLimitMu.Unlock()
+	return t.minRemainingRequests, t.rateLimitSeen
+}
+
+// Progress bar modes
+const (
+	ProgressTokens   = "tokens"
+	ProgressRequests = "requests"
+)
+
+// Progress bar styles
+const (
+	ProgressStyleBar        = "bar"
+	ProgressStyleSpinner    = "spinner"    // No total, only the count and rate
+	ProgressStylePercentage = "percentage" // The percentage without the bar
+	ProgressStyleNone       = "none"       // Rendered to io.Discard
+)
+
+// APIs that can be benchmarked
+const (
+	APIChat      = "chat"      // Chat completions
+	APIResponses = "responses" // Responses API
+	APITriton    = "triton"    // Triton HTTP generate_stream protocol
+)
+
 type SpeedMeasurement struct {
 	BaseUrl                string
 	ApiVersion             string
@@ -51,34 +127,210 @@ type SpeedMeasurement struct {
 	Concurrency            int
 	Headers                map[string]string
 	UseMaxCompletionTokens bool
+	NumMessages            int
+	BurstSize              int
+	BurstDelay             time.Duration
+
+	InjectLatency            time.Duration
+	InjectLatencyProbability float64
+	MeasureColdTtft          bool
+	MaxRetries               int
+	Timeout                  time.Duration // Per-request timeout including the streamed body, 0 for none
+	ProgressMode             string        // ProgressTokens (default) or ProgressRequests
+	ProgressSpinner          bool          // The bar has no total, which must not be shrunk for short responses
+	PrewarmConnections       bool          // Open one connection per request before the level, sized into the pool only without HTTPClient
+	DisableKeepAlives        bool          // Applied to the transport only without HTTPClient, whose transport must set it itself
+	ValidateStreams          bool
+	CookieJar                http.CookieJar // Shared by all requests, must be safe for concurrent use
+	TLSConfig                *tls.Config    // Client certificates, CA bundle and verification settings, nil for the defaults; applied to the transport only without HTTPClient
+	Tools                    *api.ToolConfig
+	API                      string        // APIChat (default), APIResponses or APITriton
+	SampleInterval           time.Duration // Record a throughput time series with this resolution, 0 to disable
+	ReadTokens               int           // Cancel every request after this many tokens, 0 to read whole responses
+	RequestBody              []byte        // Raw chat completion request sent instead of the one built from the settings
+	Tokenizer                api.Tokenizer // Recounts every completion locally for NormalizedCompletionTokens, nil to skip
+	SoftDeadline             time.Duration // Abandon requests running longer than this, keeping their streamed tokens, 0 for none
+	RegionHeader             string        // Response header naming the region that served a request, e.g. cf-ray
+	DetectCache              bool          // Estimate cache hits from identical responses, see SpeedResult.CacheHitEstimate
+	InterleaveModel          string        // Second model that every other request is sent to, see interleaved
+	HTTPClient               *http.Client  // Client whose transport requests are sent through instead of http.DefaultTransport, must not add headers itself; its transport replaces TLSConfig, DisableKeepAlives and the pool sizing of PrewarmConnections
+	UniquePrompts            bool          // Send a distinct prompt with every request
+	PromptSeed               int64         // Seed of the first request's prompt with UniquePrompts, the others follow by index
+	InputSeed                int64         // Seed of the random input of request 0, the others follow by index; 0 for unseeded input
+	IncludeRawData           bool          // Keep per-request values such as TimeoutAtTtft in the result
+	SplitBaseUrl             string        // Second endpoint that receives a share of every level's requests
+	SplitWeight              float64       // Share of the requests sent to SplitBaseUrl
+	Recorder                 *TraceRecorder
+	Replay                   []TraceEntry // Requests to reissue instead of Concurrency generated ones
 }
 
+// SpeedResult holds the metrics of one concurrency level. Throughput metrics are in tokens/s:
+//   - GenerationSpeed: completion tokens over the wall-clock duration of the level (decode)
+//   - PromptThroughput: aggregate prefill rate, the sum over requests of prompt tokens over that request's TTFT
+//   - PrefillSpeed: mean per-request prefill rate, a request's prompt tokens over its own TTFT
+//   - TotalThroughput: prompt plus completion tokens over the wall-clock duration
 type SpeedResult struct {
 	Concurrency           int     `json:"concurrency" yaml:"concurrency"`
 	GenerationSpeed       float64 `json:"generation_speed" yaml:"generation-speed"`
 	PromptThroughput      float64 `json:"prompt_throughput" yaml:"prompt-throughput"`
+	PrefillSpeed          float64 `json:"prefill_speed" yaml:"prefill-speed"`
 	TotalThroughput       float64 `json:"total_throughput" yaml:"total-throughput"`
 	MaxTtft               float64 `json:"max_ttft" yaml:"max-ttft"`
 	MinTtft               float64 `json:"min_ttft" yaml:"min-ttft"`
 	AvgTtft               float64 `json:"avg_ttft" yaml:"avg-ttft"`
 	MedianTtft            float64 `json:"median_ttft" yaml:"median-ttft"`
+	P75Ttft               float64 `json:"p75_ttft" yaml:"p75-ttft"`
 	P95Ttft               float64 `json:"p95_ttft" yaml:"p95-ttft"`
 	P99Ttft               float64 `json:"p99_ttft" yaml:"p99-ttft"`
 	StdDevTtft            float64 `json:"stddev_ttft" yaml:"stddev-ttft"`
 	SuccessRate           float64 `json:"success_rate" yaml:"success-rate"`
 	SuccessfulRequests    int     `json:"successful_requests" yaml:"successful-requests"`
 	FailedRequests        int     `json:"failed_requests" yaml:"failed-requests"`
+	AbandonedRequests     int     `json:"abandoned_requests" yaml:"abandoned-requests"` // Cancelled at the soft deadline, their streamed tokens are counted
+	AbandonedRate         float64 `json:"abandoned_rate" yaml:"abandoned-rate"`
 	TotalPromptTokens     int     `json:"total_prompt_tokens" yaml:"total-prompt-tokens"`
 	TotalCompletionTokens int     `json:"total_completion_tokens" yaml:"total-completion-tokens"`
-	AvgPromptTokens       float64 `json:"avg_prompt_tokens" yaml:"avg-prompt-tokens"`
-	AvgCompletionTokens   float64 `json:"avg_completion_tokens" yaml:"avg-completion-tokens"`
-	Duration              float64 `json:"duration" yaml:"duration"`
+	// Only set with a Tokenizer: completion tokens recounted locally and the generation speed in those tokens
+	NormalizedCompletionTokens int     `json:"normalized_completion_tokens,omitempty" yaml:"normalized-completion-tokens,omitempty"`
+	NormalizedTokenThroughput  float64 `json:"normalized_token_throughput,omitempty" yaml:"normalized-token-throughput,omitempty"`
+	AvgPromptTokens            float64 `json:"avg_prompt_tokens" yaml:"avg-prompt-tokens"`
+	AvgCompletionTokens        float64 `json:"avg_completion_tokens" yaml:"avg-completion-tokens"`
+	NormalizedGenerationSpeed  float64 `json:"normalized_generation_speed" yaml:"normalized-generation-speed"` // See NormalizedGenerationSpeed
+	Duration                   float64 `json:"duration" yaml:"duration"`
+	Repeats                    int     `json:"repeats" yaml:"repeats"`
+	GenerationSpeedStdDev      float64 `json:"generation_speed_stddev" yaml:"generation-speed-stddev"`
+	RunToRunCV                 float64 `json:"run_to_run_cv" yaml:"run-to-run-cv"`
+	P95TtftStdDev              float64 `json:"p95_ttft_stddev" yaml:"p95-ttft-stddev"`
+	P95TtftUnstable            bool    `json:"p95_ttft_unstable" yaml:"p95-ttft-unstable"`
+	BurstAvgTtft               float64 `json:"burst_avg_ttft,omitempty" yaml:"burst-avg-ttft,omitempty"`
+	BurstP95Ttft               float64 `json:"burst_p95_ttft,omitempty" yaml:"burst-p95-ttft,omitempty"`
+	SustainedAvgTtft           float64 `json:"sustained_avg_ttft,omitempty" yaml:"sustained-avg-ttft,omitempty"`
+	SustainedP95Ttft           float64 `json:"sustained_p95_ttft,omitempty" yaml:"sustained-p95-ttft,omitempty"`
+	InjectedLatencyCount       int     `json:"injected_latency_count,omitempty" yaml:"injected-latency-count,omitempty"`
+	ConnectionResetRetries     int     `json:"connection_reset_retries" yaml:"connection-reset-retries"`
+	AllocBytesPerRequest       uint64  `json:"alloc_bytes_per_request" yaml:"alloc-bytes-per-request"`
+	TotalAllocBytes            uint64  `json:"total_alloc_bytes" yaml:"total-alloc-bytes"`
+	NumGC                      uint32  `json:"num_gc" yaml:"num-gc"`
+	GCPauseMs                  float64 `json:"gc_pause_ms" yaml:"gc-pause-ms"`
+	MinRemainingRateLimit      int     `json:"min_remaining_rate_limit" yaml:"min-remaining-rate-limit"` // -1 if the server sent no rate limit headers
+	ToolCallResponses          int     `json:"tool_call_responses" yaml:"tool-call-responses"`
+	UnterminatedStreams        int     `json:"unterminated_streams,omitempty" yaml:"unterminated-streams,omitempty"`
+	PartialResponses           int     `json:"partial_responses,omitempty" yaml:"partial-responses,omitempty"` // Cancelled after ReadTokens, left out of the generation speed
+	TruncatedResponses         int     `json:"truncated_responses" yaml:"truncated-responses"`                 // Stopped by max_tokens (finish_reason "length")
+	MissingUsageResponses      int     `json:"missing_usage_responses" yaml:"missing-usage-responses"`         // Sent without usage, counted by estimate
+	ConnectionSetupMs          float64 `json:"connection_setup_ms" yaml:"connection-setup-ms"`
+	NewConnections             int     `json:"new_connections" yaml:"new-connections"`
+	ReusedConnections          int     `json:"reused_connections" yaml:"reused-connections"`
+	NewConnRequests            int     `json:"new_conn_requests" yaml:"new-conn-requests"`       // Successful requests that dialed a new connection
+	ReusedConnRequests         int     `json:"reused_conn_requests" yaml:"reused-conn-requests"` // Successful requests on a pooled connection
+	NewConnAvgTtft             float64 `json:"new_conn_avg_ttft" yaml:"new-conn-avg-ttft"`
+	NewConnP95Ttft             float64 `json:"new_conn_p95_ttft" yaml:"new-conn-p95-ttft"`
+	ReusedConnAvgTtft          float64 `json:"reused_conn_avg_ttft" yaml:"reused-conn-avg-ttft"`
+	ReusedConnP95Ttft          float64 `json:"reused_conn_p95_ttft" yaml:"reused-conn-p95-ttft"`
+	AvgRequestBytes            float64 `json:"avg_request_bytes" yaml:"avg-request-bytes"`
+	AvgResponseBytes           float64 `json:"avg_response_bytes" yaml:"avg-response-bytes"`
+	ColdTtft                   float64 `json:"cold_ttft,omitempty" yaml:"cold-ttft,omitempty"`
+	WarmTtft                   float64 `json:"warm_ttft,omitempty" yaml:"warm-ttft,omitempty"`
+
+	LatencyAdjustmentSkipped bool `json:"latency_adjustment_skipped,omitempty" yaml:"latency-adjustment-skipped,omitempty"`
+
+	CompletionLengthStdDev float64 `json:"completion_length_stddev" yaml:"completion-length-stddev"`
+	PromptTokensStdDev     float64 `json:"prompt_tokens_stddev" yaml:"prompt-tokens-stddev"`
+	DuplicateResponseRate  float64 `json:"duplicate_response_rate" yaml:"duplicate-response-rate"` // Share of responses byte-identical to another one of the level
+	CacheHitEstimate       int     `json:"cache_hit_estimate" yaml:"cache-hit-estimate"`           // With DetectCache, responses sharing one hash if they are more than half of the level
+
+	TimeoutErrors       int       `json:"timeout_errors" yaml:"timeout-errors"`
+	AvgTimeoutElapsedMs float64   `json:"avg_timeout_elapsed_ms" yaml:"avg-timeout-elapsed-ms"`
+	TimeoutAtTtft       []float64 `json:"timeout_at_ttft,omitempty" yaml:"timeout-at-ttft,omitempty"` // Elapsed ms of every timed-out request, only with IncludeRawData
+	RateLimitErrors     int       `json:"rate_limit_errors" yaml:"rate-limit-errors"`                 // Requests rejected with 429
+	QuotaErrors         int       `json:"quota_errors" yaml:"quota-errors"`                           // Requests rejected because the account is out of quota or credit
+	QuotaError          string    `json:"quota_error,omitempty" yaml:"quota-error,omitempty"`         // Error of the first of them
+
+	// Only set with SampleInterval, repeated runs keep the series of the first run
+	TimeSeries []ThroughputSample `json:"time_series,omitempty" yaml:"time-series,omitempty"`
+
+	// Only set if the server reports its scheduler state in the response headers, see queueDepthHeaders
+	QueueSamples       int     `json:"queue_samples,omitempty" yaml:"queue-samples,omitempty"` // Responses that reported any of the values
+	AvgQueueDepth      float64 `json:"avg_queue_depth,omitempty" yaml:"avg-queue-depth,omitempty"`
+	MaxQueueDepth      float64 `json:"max_queue_depth,omitempty" yaml:"max-queue-depth,omitempty"`
+	AvgRunningRequests float64 `json:"avg_running_requests,omitempty" yaml:"avg-running-requests,omitempty"`
+	AvgKVCacheUsage    float64 `json:"avg_kv_cache_usage,omitempty" yaml:"avg-kv-cache-usage,omitempty"` // Share of the KV cache in use, 0 to 1
+
+	// Only set with InterleaveModel, see summarizeModelSwitches. The other metrics of the level then only
+	// cover the requests to ModelName, the ones to InterleavedModel are in InterleavedResults.
+	InterleavedModel   string        `json:"interleaved_model,omitempty" yaml:"interleaved-model,omitempty"`
+	InterleavedResults []SpeedResult `json:"interleaved_results,omitempty" yaml:"interleaved-results,omitempty"` // The requests to InterleavedModel, over the same window
+	ModelSwitches      int           `json:"model_switches,omitempty" yaml:"model-switches,omitempty"`           // Requests whose first token followed one of the other model
+	SwitchAvgTtft      float64       `json:"switch_avg_ttft,omitempty" yaml:"switch-avg-ttft,omitempty"`
+	SameModelAvgTtft   float64       `json:"same_model_avg_ttft,omitempty" yaml:"same-model-avg-ttft,omitempty"`
+	ModelSwitchPenalty float64       `json:"model_switch_penalty,omitempty" yaml:"model-switch-penalty,omitempty"` // SwitchAvgTtft minus SameModelAvgTtft
+	TtftSpikes         int           `json:"ttft_spikes,omitempty" yaml:"ttft-spikes,omitempty"`                   // Successful requests above ttftSpikeFactor times the median TTFT
+	SwitchTtftSpikes   int           `json:"switch_ttft_spikes,omitempty" yaml:"switch-ttft-spikes,omitempty"`     // Spikes of requests that followed a model switch
+
+	// Only set with RegionHeader, the most used region first
+	Regions []RegionResult `json:"regions,omitempty" yaml:"regions,omitempty"`
+
+	// Only set for levels split across two endpoints
+	BaseUrl   string        `json:"base_url,omitempty" yaml:"base-url,omitempty"`
+	Endpoints []SpeedResult `json:"endpoints,omitempty" yaml:"endpoints,omitempty"`
+
+	// Only set in the summary of all levels, see SummarizeResults
+	MinGenerationSpeed float64 `json:"min_generation_speed,omitempty" yaml:"min-generation-speed,omitempty"`
+	MaxGenerationSpeed float64 `json:"max_generation_speed,omitempty" yaml:"max-generation-speed,omitempty"`
+}
+
+// MaxLatencyFraction is the largest share of the measured window that the network latency may take up
+// before it is no longer subtracted. Beyond it the subtraction would dominate, not correct, the result.
+const MaxLatencyFraction = 0.1
+
+// latencyAdjustedWindow returns the measured window in seconds minus the network latency in milliseconds.
+// If the latency is too large a share of the window, the window is returned unadjusted and ok is false.
+func latencyAdjustedWindow(window float64, latencyMs float64) (adjusted float64, ok bool) {
+	latency := latencyMs / 1000
+	if latency < 0 || latency > window*MaxLatencyFraction {
+		return window, false
+	}
+	return window - latency, true
+}
+
+// ScaleTimeout returns the per-request timeout for a concurrency level. Queueing makes requests at
+// higher concurrency legitimately slower, so every request beyond the first adds scale times base.
+func ScaleTimeout(base time.Duration, scale float64, concurrency int) time.Duration {
+	if base <= 0 || scale <= 0 || concurrency <= 1 {
+		return base
+	}
+	return time.Duration(float64(base) * (1 + scale*float64(concurrency-1)))
+}
+
+// Precision is the number of decimal places results are rounded to and rendered with, set by --precision.
+// Ratios keep two decimals, see roundRatio.
+var Precision = 2
+
+// RoundToPrecision rounds f to Precision decimal places.
+func RoundToPrecision(f float64) float64 {
+	scale := math.Pow10(Precision)
+	return math.Round(f*scale) / scale
 }
 
-func roundToTwoDecimals(f float64) float64 {
+// roundRatio rounds a ratio or share to two decimals regardless of Precision. Ratios such as
+// RunToRunCV are compared against thresholds, which must not depend on how results are displayed.
+func roundRatio(f float64) float64 {
 	return math.Round(f*100) / 100
 }
 
+// NormalizedGenerationSpeed discounts the generation speed by the prefill cost of long prompts, so that
+// runs with different --num-words can be compared: GenerationSpeed / (1 + AvgPromptTokens/1000).
+// Every 1000 prompt tokens count as much as the decode work; it is a rough rule of thumb, not a model
+// of any particular server.
+func NormalizedGenerationSpeed(generationSpeed, avgPromptTokens float64) float64 {
+	return RoundToPrecision(generationSpeed / (1 + avgPromptTokens/1000))
+}
+
+// FormatFloat renders f with Precision decimal places.
+func FormatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', Precision, 64)
+}
+
 func calculatePercentile(values []float64, percentile float64) float64 {
 	if len(values) == 0 {
 		return 0
@@ -97,6 +349,17 @@ func calculatePercentile(values []float64, percentile float64) float64 {
 	return sorted[index]
 }
 
+func calculateMean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
 func calculateStdDev(values []float64, mean float64) float64 {
 	if len(values) == 0 {
 		return 0
@@ -110,91 +373,487 @@ func calculateStdDev(values []float64, mean float64) float64 {
 
 // Run measures API generation throughput and TTFT.
 func (setup *SpeedMeasurement) Run(bar *progressbar.ProgressBar) (SpeedResult, error) {
+	if len(setup.Replay) > 0 {
+		setup.Concurrency = len(setup.Replay)
+	}
+
 	config := openai.DefaultConfig(setup.ApiKey)
 	config.BaseURL = setup.BaseUrl
 	config.APIVersion = setup.ApiVersion
-	
-	// Setup HTTP client with custom headers if specified
-	if len(setup.Headers) > 0 {
-		config.HTTPClient = &http.Client{
-			Transport: &HeaderTransport{
-				Base:      http.DefaultTransport,
-				Headers:   setup.Headers,
-				AuthToken: setup.ApiKey,
-			},
+
+	var baseTransport http.RoundTripper = http.DefaultTransport
+	switch {
+	case setup.HTTPClient != nil:
+		// The caller's transport carries its own TLS, keep-alive and pool settings
+		if setup.HTTPClient.Transport != nil {
+			baseTransport = setup.HTTPClient.Transport
+		}
+	case setup.PrewarmConnections || setup.DisableKeepAlives || setup.TLSConfig != nil:
+		// Pre-warming only helps if the pool may keep one idle connection per request
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		tr.TLSClientConfig = setup.TLSConfig
+		tr.MaxIdleConnsPerHost = max(tr.MaxIdleConnsPerHost, setup.Concurrency)
+		tr.DisableKeepAlives = setup.DisableKeepAlives
+		baseTransport = tr
+	}
+	poolTransport := baseTransport
+	tracer := &traceTransport{Base: poolTransport}
+	baseTransport = tracer
+
+	// Setup HTTP client with custom headers, latency injection and rate limit tracking
+	transport := &HeaderTransport{
+		Base:                     baseTransport,
+		Headers:                  setup.Headers,
+		AuthToken:                setup.ApiKey,
+		InjectLatency:            setup.InjectLatency,
+		InjectLatencyProbability: setup.InjectLatencyProbability,
+	}
+	var roundTripper http.RoundTripper = transport
+	if len(setup.RequestBody) > 0 {
+		roundTripper = &api.RequestBodyTransport{Base: transport, Body: setup.RequestBody}
+	}
+	httpClient := &http.Client{Transport: roundTripper, Jar: setup.CookieJar, Timeout: setup.Timeout}
+	config.HTTPClient = httpClient
+
+	clients := []endpointClients{setup.newEndpointClients(config, httpClient, setup.BaseUrl)}
+
+	// With a split endpoint, both share the transport and thereby the measured wall-clock conditions
+	if setup.SplitBaseUrl != "" {
+		clients = append(clients, setup.newEndpointClients(config, httpClient, setup.SplitBaseUrl))
+	}
+
+	if setup.PrewarmConnections {
+		warmed := prewarmConnections(&http.Client{Transport: poolTransport}, setup.BaseUrl, setup.Concurrency)
+		if warmed == setup.Concurrency {
+			log.Printf("Pre-warmed %d connections", warmed)
+		} else {
+			log.Printf("Warning: pre-warmed only %d of %d connections", warmed, setup.Concurrency)
+		}
+	}
+
+	// Compare a request on a freshly dialed connection with one on a pooled connection
+	var coldTtft, warmTtft float64
+	if setup.MeasureColdTtft {
+		var err error
+		coldTtft, warmTtft, err = setup.measureConnectionTtft(poolTransport, config)
+		if err != nil {
+			return SpeedResult{}, err
 		}
 	}
-	
-	client := openai.NewClientWithConfig(config)
+
+	// Only the timed requests below should be traced
+	tracer.reset()
 
 	var wg sync.WaitGroup
-	var responseTokens sync.Map
-	var promptTokens sync.Map
-	var ttfts sync.Map
-	var successfulRequests atomic.Int32
-	var failedRequests atomic.Int32
+	outcomes := newRequestOutcomes(setup.Concurrency)
+	endpoints := make([]int, setup.Concurrency) // 1 for requests sent to SplitBaseUrl
+	var connectionResetRetries atomic.Int32
+	var unterminatedStreams atomic.Int32
+	var toolCallResponses atomic.Int32
+	var truncatedResponses atomic.Int32
+	var missingUsage atomic.Int32
+
+	// Sample memory statistics around the whole level rather than per request,
+	// ReadMemStats stops the world and would skew individual timings.
+	var memBefore, memAfter runtime.MemStats
+	runtime.ReadMemStats(&memBefore)
 
 	start := time.Now()
+	var sampler *throughputSampler
+	if setup.SampleInterval > 0 {
+		sampler = startThroughputSampler(setup.SampleInterval, start)
+	}
+
+	// When a burst is configured, the first BurstSize requests go out at once and
+	// the remaining ones only after BurstDelay has passed.
+	burstSize := setup.Concurrency
+	if setup.BurstSize > 0 && setup.BurstSize < setup.Concurrency {
+		burstSize = setup.BurstSize
+	}
+
+	record := setup.Recorder != nil && setup.Recorder.begin(setup.Concurrency)
 
 	// Send requests concurrently (restored from debugging version)
 	for i := 0; i < setup.Concurrency; i++ {
+		if len(setup.Replay) > 0 {
+			// Reissue recorded requests with their original inter-arrival timing
+			time.Sleep(time.Until(start.Add(time.Duration(setup.Replay[i].Offset * float64(time.Second)))))
+		} else if i == burstSize && setup.BurstDelay > 0 {
+			time.Sleep(setup.BurstDelay)
+		}
+		offset := time.Since(start).Seconds()
+		if setup.SplitBaseUrl != "" && math.Floor(float64(i+1)*setup.SplitWeight) > math.Floor(float64(i)*setup.SplitWeight) {
+			// Spreads the share of SplitBaseUrl evenly over the level instead of sending it in one block
+			endpoints[i] = 1
+		}
 		wg.Add(1)
 		go func(index int) {
 			defer wg.Done()
 			var ttft float64
 			var completionTokens, inputTokens int
 			var err error
-			if setup.UseRandomInput {
-				ttft, completionTokens, inputTokens, err = api.AskOpenAiRandomInput(client, setup.ModelName, setup.NumWords, setup.MaxTokens, setup.UseMaxCompletionTokens, bar)
-			} else {
-				ttft, completionTokens, inputTokens, err = api.AskOpenAi(client, setup.ModelName, setup.Prompt, setup.MaxTokens, setup.UseMaxCompletionTokens, bar)
+			stats := api.RequestStats{Index: index, ReadTokens: setup.ReadTokens, Tokenizer: setup.Tokenizer, SoftDeadline: setup.SoftDeadline}
+			// The body of a --request-file is sent instead of the built request, so there is nothing to show
+			stats.LogRequestBody = index == 0 && setup.RequestBody == nil
+			if sampler != nil {
+				stats.StreamedTokens = &sampler.tokens
+				sampler.active.Add(1)
+				defer sampler.active.Add(-1)
+			}
+			tokenBar := bar
+			if setup.ProgressMode == ProgressRequests {
+				tokenBar = nil
+			}
+			prompt, randomInput := setup.Prompt, setup.UseRandomInput
+			maxTokens, numMessages := setup.MaxTokens, setup.NumMessages
+			if len(setup.Replay) > 0 {
+				entry := setup.Replay[index]
+				prompt, randomInput = entry.Prompt, false
+				maxTokens, numMessages = entry.MaxTokens, entry.NumMessages
+			}
+			if setup.UniquePrompts && len(setup.Replay) == 0 {
+				// Every request gets its own reproducible prompt, none can be served from a prompt cache
+				seed := setup.InputSeed + setup.PromptSeed + int64(index)
+				if randomInput {
+					prompt, randomInput = api.GenerateSeededPhrase(seed, setup.NumWords), false
+				} else {
+					prompt = api.PrefixNonce(seed, prompt)
+				}
+			} else if setup.InputSeed != 0 && randomInput {
+				// The same seed sends the same prompt at the same index in every level and run
+				prompt, randomInput = api.GenerateSeededPhrase(setup.InputSeed+int64(index), setup.NumWords), false
+			}
+			model := setup.ModelName
+			if setup.interleaved(index) {
+				model = setup.InterleaveModel
+			}
+			requestStart := time.Now()
+			ttft, completionTokens, inputTokens, err = setup.ask(clients[endpoints[index]], model, prompt, randomInput, maxTokens, numMessages, &stats, tokenBar)
+			if record {
+				entry := TraceEntry{
+					Concurrency: setup.Concurrency,
+					Index:       index,
+					Offset:      offset,
+					Prompt:      stats.Prompt,
+					MaxTokens:   maxTokens,
+					NumMessages: numMessages,
+				}
+				if err := setup.Recorder.record(entry); err != nil {
+					log.Printf("Warning: failed to record request %d: %v", index, err)
+				}
+			}
+			connectionResetRetries.Add(int32(stats.ConnectionResetRetries))
+			if bar != nil {
+				if setup.ProgressMode == ProgressRequests {
+					bar.Add(1)
+				} else if !setup.ProgressSpinner {
+					// The request stopped short of its limit or failed, shrink the expected total to the
+					// tokens the bar was actually advanced by
+					if stats.BarTokens < maxTokens {
+						bar.AddMax(stats.BarTokens - maxTokens)
+					}
+				}
 			}
 			if err != nil {
-				failedRequests.Add(1)
+				if api.IsTimeout(err) {
+					outcomes.timeoutElapsedMs[index] = float64(time.Since(requestStart).Microseconds()) / 1000
+				}
+				var requestErr *api.RequestError
+				if errors.As(err, &requestErr) && requestErr.IsRateLimited() {
+					outcomes.rateLimited[index] = true
+				}
+				if errors.As(err, &requestErr) && requestErr.IsQuotaExceeded() {
+					outcomes.quotaErrors[index] = requestErr.Error()
+				}
 				return
 			}
-			successfulRequests.Add(1)
-			ttfts.Store(index, ttft)
-			responseTokens.Store(index, completionTokens)
-			promptTokens.Store(index, inputTokens)
+			if stats.ToolCall {
+				toolCallResponses.Add(1)
+			}
+			if stats.FinishReason == string(openai.FinishReasonLength) {
+				truncatedResponses.Add(1)
+			}
+			if stats.UsageMissing {
+				missingUsage.Add(1)
+			}
+			if setup.ValidateStreams && stats.FinishReason == "" && !stats.Abandoned {
+				unterminatedStreams.Add(1)
+			}
+			outcomes.succeeded[index] = !stats.Abandoned
+			outcomes.abandoned[index] = stats.Abandoned
+			outcomes.ttfts[index] = ttft
+			outcomes.firstTokens[index] = requestStart.Sub(start).Seconds() + ttft
+			outcomes.responseTokens[index] = completionTokens
+			outcomes.promptTokens[index] = inputTokens
+			outcomes.responseHashes[index] = stats.ResponseHash
+			outcomes.partial[index] = stats.PartialRead
+			outcomes.reused[index] = stats.ConnectionReused
+			outcomes.normalizedTokens[index] = stats.NormalizedTokens
+			if setup.RegionHeader != "" {
+				outcomes.regions[index] = regionOf(setup.RegionHeader, stats.ResponseHeader)
+			}
+			outcomes.queue[index] = queueStateOf(stats.ResponseHeader)
 		}(i)
 	}
 
 	wg.Wait()
 	duration := time.Since(start)
-
-	// Calculate total tokens
-	totalResponseTokens := 0
-	responseTokens.Range(func(_, value interface{}) bool {
-		totalResponseTokens += value.(int)
-		return true
-	})
-
-	totalPromptTokens := 0
-	promptTokens.Range(func(_, value interface{}) bool {
-		totalPromptTokens += value.(int)
-		return true
-	})
+	runtime.ReadMemStats(&memAfter)
 
 	measurement := SpeedResult{}
 	measurement.Concurrency = setup.Concurrency
+	measurement.TotalAllocBytes = memAfter.TotalAlloc - memBefore.TotalAlloc
+	measurement.NumGC = memAfter.NumGC - memBefore.NumGC
+	measurement.GCPauseMs = RoundToPrecision(float64(memAfter.PauseTotalNs-memBefore.PauseTotalNs) / 1e6)
+	if setup.Concurrency > 0 {
+		measurement.AllocBytesPerRequest = measurement.TotalAllocBytes / uint64(setup.Concurrency)
+	}
+	measurement.ConnectionSetupMs = RoundToPrecision(tracer.avgSetupMs())
+	measurement.NewConnections, measurement.ReusedConnections = tracer.connections()
+	if sampler != nil {
+		measurement.TimeSeries = sampler.finish()
+	}
+	avgRequestBytes, avgResponseBytes := tracer.avgBytes()
+	measurement.AvgRequestBytes = RoundToPrecision(avgRequestBytes)
+	measurement.AvgResponseBytes = RoundToPrecision(avgResponseBytes)
+	measurement.ColdTtft = RoundToPrecision(coldTtft)
+	measurement.WarmTtft = RoundToPrecision(warmTtft)
+	measurement.InjectedLatencyCount = int(transport.injectedLatencyCount.Load())
+	measurement.MinRemainingRateLimit = -1
+	if remaining, ok := transport.MinRemainingRequests(); ok {
+		measurement.MinRemainingRateLimit = remaining
+	}
+	measurement.ConnectionResetRetries = int(connectionResetRetries.Load())
+	measurement.UnterminatedStreams = int(unterminatedStreams.Load())
+	measurement.ToolCallResponses = int(toolCallResponses.Load())
+	measurement.TruncatedResponses = int(truncatedResponses.Load())
+	measurement.MissingUsageResponses = int(missingUsage.Load())
+
+	// With a second model the headline numbers only cover the requests to ModelName
+	var primary, interleaved []bool
+	if setup.InterleaveModel != "" {
+		primary, interleaved = make([]bool, setup.Concurrency), make([]bool, setup.Concurrency)
+		for i := range interleaved {
+			interleaved[i] = setup.interleaved(i)
+			primary[i] = !interleaved[i]
+		}
+	}
+	outcomes.summarize(&measurement, primary, duration, burstSize, setup.Latency)
+	if setup.DetectCache {
+		measurement.CacheHitEstimate = outcomes.cacheHitEstimate()
+	}
+	if setup.InterleaveModel != "" {
+		interleavedResult := SpeedResult{MinRemainingRateLimit: -1}
+		for _, ok := range interleaved {
+			if ok {
+				interleavedResult.Concurrency++
+			}
+		}
+		outcomes.summarize(&interleavedResult, interleaved, duration, burstSize, setup.Latency)
+		interleavedResult.TimeoutAtTtft = nil
+		measurement.InterleavedModel = setup.InterleaveModel
+		measurement.InterleavedResults = []SpeedResult{interleavedResult}
+		outcomes.summarizeModelSwitches(&measurement, interleaved)
+	}
+	if !setup.IncludeRawData {
+		measurement.TimeoutAtTtft = nil
+	}
+
+	// Report each endpoint of a split level on its own, measured over the same wall-clock window
+	if setup.SplitBaseUrl != "" {
+		for e, baseURL := range []string{setup.BaseUrl, setup.SplitBaseUrl} {
+			include := make([]bool, setup.Concurrency)
+			endpointResult := SpeedResult{BaseUrl: baseURL, MinRemainingRateLimit: -1}
+			for i := range include {
+				include[i] = endpoints[i] == e
+				if include[i] {
+					endpointResult.Concurrency++
+				}
+			}
+			outcomes.summarize(&endpointResult, include, duration, burstSize, setup.Latency)
+			endpointResult.TimeoutAtTtft = nil
+			measurement.Endpoints = append(measurement.Endpoints, endpointResult)
+		}
+	}
+
+	return measurement, nil
+}
+
+// requestOutcomes holds the results of the individual requests of a level, indexed by request.
+// Each request goroutine only writes its own index, so the slices need no locking.
+type requestOutcomes struct {
+	succeeded      []bool
+	ttfts          []float64
+	firstTokens    []float64 // Seconds from the start of the level to the first token
+	responseTokens []int
+	promptTokens   []int
+	responseHashes []uint64
+	// Time from sending to cancellation of requests that timed out, 0 for all others
+	timeoutElapsedMs []float64
+	partial          []bool        // Cancelled after ReadTokens tokens
+	reused           []bool        // Sent on a pooled keep-alive connection
+	rateLimited      []bool        // Rejected with 429 Too Many Requests
+	quotaErrors      []string      // Error of requests rejected because the account is out of quota, empty for all others
+	normalizedTokens []int         // Completion tokens recounted by the Tokenizer
+	abandoned        []bool        // Cancelled at the SoftDeadline, neither succeeded nor failed
+	regions          []string      // Region named by the RegionHeader, empty without one
+	queue            []*queueState // Scheduler state reported in the response header, nil if none
+}
+
+func newRequestOutcomes(n int) *requestOutcomes {
+	return &requestOutcomes{
+		succeeded:      make([]bool, n),
+		ttfts:          make([]float64, n),
+		firstTokens:    make([]float64, n),
+		responseTokens: make([]int, n),
+		promptTokens:   make([]int, n),
+		responseHashes: make([]uint64, n),
+
+		timeoutElapsedMs: make([]float64, n),
+		partial:          make([]bool, n),
+		reused:           make([]bool, n),
+		rateLimited:      make([]bool, n),
+		quotaErrors:      make([]string, n),
+		normalizedTokens: make([]int, n),
+		abandoned:        make([]bool, n),
+		regions:          make([]string, n),
+		queue:            make([]*queueState, n),
+	}
+}
+
+// cacheHitEstimate returns the number of successful responses sharing the most common hash if they are
+// more than half of the level, 0 otherwise. A server-side cache answering repeated prompts (typically
+// with temperature 0 and a seed) returns the same response to most of them.
+func (outcomes *requestOutcomes) cacheHitEstimate() int {
+	counts := make(map[uint64]int)
+	var hashed, mostCommon int
+	for i, ok := range outcomes.succeeded {
+		hash := outcomes.responseHashes[i]
+		if !ok || hash == 0 {
+			continue
+		}
+		hashed++
+		counts[hash]++
+		mostCommon = max(mostCommon, counts[hash])
+	}
+	if mostCommon > 1 && mostCommon*2 > hashed {
+		return mostCommon
+	}
+	return 0
+}
+
+// summarize fills the metrics derived from the individual requests into measurement. Only requests
+// with include set are counted, a nil include counts all of them. burstSize is the number of requests
+// of the initial burst and latency the network latency in milliseconds.
+func (outcomes *requestOutcomes) summarize(measurement *SpeedResult, include []bool, duration time.Duration, burstSize int, latency float64) {
+	succeeded, ttfts := outcomes.succeeded, outcomes.ttfts
+	responseTokens, promptTokens := outcomes.responseTokens, outcomes.promptTokens
+	if include == nil {
+		include = make([]bool, len(succeeded))
+		for i := range include {
+			include[i] = true
+		}
+	}
+
+	// Calculate total tokens. Partially read responses say nothing about the generation speed.
+	totalResponseTokens := 0
+	totalPromptTokens := 0
+	generatedTokens := 0
+	normalizedGeneratedTokens := 0
+	for i := range succeeded {
+		if !include[i] {
+			continue
+		}
+		totalResponseTokens += responseTokens[i]
+		totalPromptTokens += promptTokens[i]
+		measurement.NormalizedCompletionTokens += outcomes.normalizedTokens[i]
+		if outcomes.partial[i] {
+			measurement.PartialResponses++
+		} else {
+			generatedTokens += responseTokens[i]
+			normalizedGeneratedTokens += outcomes.normalizedTokens[i]
+		}
+	}
 
 	// Calculate success/failed requests
-	measurement.SuccessfulRequests = int(successfulRequests.Load())
-	measurement.FailedRequests = int(failedRequests.Load())
+	totalRequests := 0
+	for i, ok := range succeeded {
+		if include[i] {
+			totalRequests++
+			if ok {
+				measurement.SuccessfulRequests++
+			} else if outcomes.abandoned[i] {
+				measurement.AbandonedRequests++
+			}
+		}
+	}
+	measurement.FailedRequests = totalRequests - measurement.SuccessfulRequests - measurement.AbandonedRequests
 
-	// Calculate success rate
-	totalRequests := setup.Concurrency
+	// Calculate success rate, abandoned requests did not finish either way and are left out
+	if finished := totalRequests - measurement.AbandonedRequests; finished > 0 {
+		measurement.SuccessRate = float64(measurement.SuccessfulRequests) / float64(finished)
+	}
 	if totalRequests > 0 {
-		measurement.SuccessRate = float64(measurement.SuccessfulRequests) / float64(totalRequests)
+		measurement.AbandonedRate = roundRatio(float64(measurement.AbandonedRequests) / float64(totalRequests))
 	}
 
 	// Collect TTFT values for statistics
-	var ttftValues []float64
-	ttfts.Range(func(_, value interface{}) bool {
-		ttftValues = append(ttftValues, value.(float64))
-		return true
-	})
+	// Abandoned requests that streamed content add their TTFT
+	var ttftValues, burstTtfts, sustainedTtfts, newConnTtfts, reusedConnTtfts []float64
+	for i, ok := range succeeded {
+		if !include[i] || !(ok || (outcomes.abandoned[i] && ttfts[i] > 0)) {
+			continue
+		}
+		ttftValues = append(ttftValues, ttfts[i])
+		if i < burstSize {
+			burstTtfts = append(burstTtfts, ttfts[i])
+		} else {
+			sustainedTtfts = append(sustainedTtfts, ttfts[i])
+		}
+		if outcomes.reused[i] {
+			reusedConnTtfts = append(reusedConnTtfts, ttfts[i])
+		} else {
+			newConnTtfts = append(newConnTtfts, ttfts[i])
+		}
+	}
+
+	// Report burst and sustained phases separately
+	if burstSize < len(succeeded) {
+		measurement.BurstAvgTtft = RoundToPrecision(calculateMean(burstTtfts))
+		measurement.BurstP95Ttft = RoundToPrecision(calculatePercentile(burstTtfts, 0.95))
+		measurement.SustainedAvgTtft = RoundToPrecision(calculateMean(sustainedTtfts))
+		measurement.SustainedP95Ttft = RoundToPrecision(calculatePercentile(sustainedTtfts, 0.95))
+	}
+
+	// Break the TTFT down by the region that served each request
+	var regions []string
+	var regionTtfts []float64
+	for i, ok := range succeeded {
+		if ok && include[i] && outcomes.regions[i] != "" {
+			regions = append(regions, outcomes.regions[i])
+			regionTtfts = append(regionTtfts, ttfts[i])
+		}
+	}
+	if len(regions) > 0 {
+		measurement.Regions = summarizeRegions(regions, regionTtfts)
+	}
+
+	// Scheduler state reported by the server, a hint why throughput plateaus
+	var queue []*queueState
+	for i, ok := range succeeded {
+		if ok && include[i] {
+			queue = append(queue, outcomes.queue[i])
+		}
+	}
+	summarizeQueue(measurement, queue)
+
+	// Report requests on new and on reused connections separately, the difference is the connection setup
+	measurement.NewConnAvgTtft = RoundToPrecision(calculateMean(newConnTtfts))
+	measurement.NewConnP95Ttft = RoundToPrecision(calculatePercentile(newConnTtfts, 0.95))
+	measurement.ReusedConnAvgTtft = RoundToPrecision(calculateMean(reusedConnTtfts))
+	measurement.ReusedConnP95Ttft = RoundToPrecision(calculatePercentile(reusedConnTtfts, 0.95))
+	measurement.NewConnRequests = len(newConnTtfts)
+	measurement.ReusedConnRequests = len(reusedConnTtfts)
 
 	// Calculate max, min, avg, median, P95, P99, stddev TTFT
 	if len(ttftValues) > 0 {
@@ -210,16 +869,17 @@ func (setup *SpeedMeasurement) Run(bar *progressbar.ProgressBar) (SpeedResult, e
 				measurement.MinTtft = ttft
 			}
 		}
-		measurement.AvgTtft = roundToTwoDecimals(sumTtft / float64(len(ttftValues)))
-		measurement.MedianTtft = roundToTwoDecimals(calculatePercentile(ttftValues, 0.5))
-		measurement.P95Ttft = roundToTwoDecimals(calculatePercentile(ttftValues, 0.95))
-		measurement.P99Ttft = roundToTwoDecimals(calculatePercentile(ttftValues, 0.99))
-		measurement.StdDevTtft = roundToTwoDecimals(calculateStdDev(ttftValues, measurement.AvgTtft))
+		measurement.AvgTtft = RoundToPrecision(sumTtft / float64(len(ttftValues)))
+		measurement.MedianTtft = RoundToPrecision(calculatePercentile(ttftValues, 0.5))
+		measurement.P75Ttft = RoundToPrecision(calculatePercentile(ttftValues, 0.75))
+		measurement.P95Ttft = RoundToPrecision(calculatePercentile(ttftValues, 0.95))
+		measurement.P99Ttft = RoundToPrecision(calculatePercentile(ttftValues, 0.99))
+		measurement.StdDevTtft = RoundToPrecision(calculateStdDev(ttftValues, measurement.AvgTtft))
 	}
 
-	measurement.MaxTtft = roundToTwoDecimals(measurement.MaxTtft)
-	measurement.MinTtft = roundToTwoDecimals(measurement.MinTtft)
-	measurement.Duration = roundToTwoDecimals(float64(duration.Seconds()))
+	measurement.MaxTtft = RoundToPrecision(measurement.MaxTtft)
+	measurement.MinTtft = RoundToPrecision(measurement.MinTtft)
+	measurement.Duration = RoundToPrecision(float64(duration.Seconds()))
 
 	// Store total tokens
 	measurement.TotalPromptTokens = totalPromptTokens
@@ -227,18 +887,188 @@ func (setup *SpeedMeasurement) Run(bar *progressbar.ProgressBar) (SpeedResult, e
 
 	// Calculate average tokens per request
 	if measurement.SuccessfulRequests > 0 {
-		measurement.AvgPromptTokens = roundToTwoDecimals(float64(totalPromptTokens) / float64(measurement.SuccessfulRequests))
-		measurement.AvgCompletionTokens = roundToTwoDecimals(float64(totalResponseTokens) / float64(measurement.SuccessfulRequests))
+		measurement.AvgPromptTokens = RoundToPrecision(float64(totalPromptTokens) / float64(measurement.SuccessfulRequests))
+		measurement.AvgCompletionTokens = RoundToPrecision(float64(totalResponseTokens) / float64(measurement.SuccessfulRequests))
+	}
+
+	// Spread of the prompt lengths, random prompts tokenize to slightly different lengths
+	var promptLengths []float64
+	for i, ok := range succeeded {
+		if ok && include[i] {
+			promptLengths = append(promptLengths, float64(promptTokens[i]))
+		}
+	}
+	measurement.PromptTokensStdDev = RoundToPrecision(calculateStdDev(promptLengths, calculateMean(promptLengths)))
+
+	// Spread of the completion lengths, uneven lengths leave stragglers on batching servers
+	var completionLengths []float64
+	for i, ok := range succeeded {
+		if ok && include[i] {
+			completionLengths = append(completionLengths, float64(responseTokens[i]))
+		}
+	}
+	measurement.CompletionLengthStdDev = RoundToPrecision(calculateStdDev(completionLengths, calculateMean(completionLengths)))
+
+	// Count responses identical to an earlier one, broken caching layers answer different prompts alike
+	seen := make(map[uint64]bool)
+	var hashed, duplicates int
+	for i, ok := range succeeded {
+		hash := outcomes.responseHashes[i]
+		if !ok || !include[i] || hash == 0 {
+			continue
+		}
+		hashed++
+		if seen[hash] {
+			duplicates++
+		}
+		seen[hash] = true
+	}
+	if hashed > 0 {
+		measurement.DuplicateResponseRate = roundRatio(float64(duplicates) / float64(hashed))
+	}
+
+	// Short elapsed times point at a slow TTFT, long ones at slow generation
+	for i, elapsed := range outcomes.timeoutElapsedMs {
+		if include[i] && elapsed > 0 {
+			measurement.TimeoutErrors++
+			measurement.TimeoutAtTtft = append(measurement.TimeoutAtTtft, elapsed)
+		}
+	}
+	measurement.AvgTimeoutElapsedMs = RoundToPrecision(calculateMean(measurement.TimeoutAtTtft))
+	for i, limited := range outcomes.rateLimited {
+		if include[i] && limited {
+			measurement.RateLimitErrors++
+		}
+	}
+	for i, quotaErr := range outcomes.quotaErrors {
+		if include[i] && quotaErr != "" {
+			if measurement.QuotaErrors == 0 {
+				measurement.QuotaError = quotaErr
+			}
+			measurement.QuotaErrors++
+		}
 	}
 
 	// Calculate speed (tokens/second)
-	measurement.GenerationSpeed = roundToTwoDecimals(float64(totalResponseTokens) / (duration.Seconds() - setup.Latency/1000))
+	window, adjusted := latencyAdjustedWindow(duration.Seconds(), latency)
+	measurement.LatencyAdjustmentSkipped = !adjusted
+	measurement.GenerationSpeed = RoundToPrecision(float64(generatedTokens) / window)
+	measurement.NormalizedTokenThroughput = RoundToPrecision(float64(normalizedGeneratedTokens) / window)
+	measurement.NormalizedGenerationSpeed = NormalizedGenerationSpeed(measurement.GenerationSpeed, measurement.AvgPromptTokens)
 
-	// Calculate Prompt Throughput
-	measurement.PromptThroughput = roundToTwoDecimals(float64(totalPromptTokens) / (measurement.MaxTtft - setup.Latency/1000))
+	// Calculate Prompt Throughput and Prefill Speed from each request's own prefill window
+	// (up to its first token). Requests are prefilled concurrently, so their rates add up.
+	var prefillSpeeds []float64
+	for i, ok := range succeeded {
+		prefillWindow, _ := latencyAdjustedWindow(ttfts[i], latency)
+		if ok && include[i] && prefillWindow > 0 {
+			prefillSpeeds = append(prefillSpeeds, float64(promptTokens[i])/prefillWindow)
+		}
+	}
+	var promptThroughput float64
+	for _, speed := range prefillSpeeds {
+		promptThroughput += speed
+	}
+	measurement.PromptThroughput = RoundToPrecision(promptThroughput)
+	measurement.PrefillSpeed = RoundToPrecision(calculateMean(prefillSpeeds))
 
 	// Calculate Total Throughput (prompt + completion)
-	measurement.TotalThroughput = roundToTwoDecimals(float64(totalPromptTokens+totalResponseTokens) / (duration.Seconds() - setup.Latency/1000))
+	measurement.TotalThroughput = RoundToPrecision(float64(totalPromptTokens+generatedTokens) / window)
+}
 
-	return measurement, nil
+// endpointClients holds the clients of one endpoint, one per supported API.
+type endpointClients struct {
+	chat      *openai.Client
+	responses *api.ResponsesClient
+	triton    *api.TritonClient
+}
+
+// newEndpointClients creates the clients for baseURL, all sending through httpClient.
+func (setup *SpeedMeasurement) newEndpointClients(config openai.ClientConfig, httpClient *http.Client, baseURL string) endpointClients {
+	config.BaseURL = baseURL
+	return endpointClients{
+		chat:      openai.NewClientWithConfig(config),
+		responses: &api.ResponsesClient{HTTPClient: httpClient, BaseURL: baseURL, APIKey: setup.ApiKey, APIVersion: setup.ApiVersion},
+		triton:    &api.TritonClient{HTTPClient: httpClient, BaseURL: baseURL, APIKey: setup.ApiKey},
+	}
+}
+
+// ask sends a single request through the configured API. With randomInput set, a random prompt
+// of NumWords words is sent instead of prompt.
+func (setup *SpeedMeasurement) ask(clients endpointClients, model string, prompt string, randomInput bool, maxTokens int, numMessages int, stats *api.RequestStats, bar *progressbar.ProgressBar) (float64, int, int, error) {
+	opts := setup.askOptions(prompt, maxTokens, numMessages)
+	opts.Model, opts.Stats, opts.Bar = model, stats, bar
+	switch {
+	case setup.API == APITriton && randomInput:
+		return api.AskTritonRandomInput(clients.triton, setup.NumWords, opts)
+	case setup.API == APITriton:
+		return api.AskTriton(clients.triton, opts)
+	case setup.API == APIResponses && randomInput:
+		return api.AskOpenAiResponsesRandomInput(clients.responses, setup.NumWords, opts)
+	case setup.API == APIResponses:
+		return api.AskOpenAiResponses(clients.responses, opts)
+	case randomInput:
+		return api.AskOpenAiRandomInput(clients.chat, setup.NumWords, opts)
+	default:
+		return api.AskOpenAi(clients.chat, opts)
+	}
+}
+
+// askOptions returns the request parameters of the measurement for a prompt.
+func (setup *SpeedMeasurement) askOptions(prompt string, maxTokens int, numMessages int) api.AskOptions {
+	return api.AskOptions{
+		Model:                  setup.ModelName,
+		Prompt:                 prompt,
+		MaxTokens:              maxTokens,
+		UseMaxCompletionTokens: setup.UseMaxCompletionTokens,
+		NumMessages:            numMessages,
+		MaxRetries:             setup.MaxRetries,
+		Tools:                  setup.Tools,
+	}
+}
+
+// measureConnectionTtft returns the TTFT of a request sent on a new connection and of one sent on a
+// connection that is already in the pool. A warm-up request makes sure the pool has an idle connection.
+// The probes share only the pool with the benchmark, they are not counted by its transport.
+func (setup *SpeedMeasurement) measureConnectionTtft(poolTransport http.RoundTripper, config openai.ClientConfig) (float64, float64, error) {
+	ask := func(base http.RoundTripper) (float64, error) {
+		probeConfig := config
+		probeConfig.HTTPClient = setup.probeClient(base)
+		ttft, _, _, err := api.AskOpenAi(openai.NewClientWithConfig(probeConfig), setup.askOptions(setup.Prompt, 4, setup.NumMessages))
+		return ttft, err
+	}
+
+	var coldTransport *http.Transport
+	if tr, ok := poolTransport.(*http.Transport); ok {
+		coldTransport = tr.Clone()
+	} else {
+		coldTransport = http.DefaultTransport.(*http.Transport).Clone()
+		coldTransport.TLSClientConfig = setup.TLSConfig
+	}
+	coldTransport.DisableKeepAlives = true
+
+	coldTtft, err := ask(coldTransport)
+	if err != nil {
+		return 0, 0, fmt.Errorf("cold connection request: %w", err)
+	}
+
+	if _, err := ask(poolTransport); err != nil {
+		return 0, 0, fmt.Errorf("connection warm-up request: %w", err)
+	}
+	warmTtft, err := ask(poolTransport)
+	if err != nil {
+		return 0, 0, fmt.Errorf("warm connection request: %w", err)
+	}
+
+	return coldTtft, warmTtft, nil
+}
+
+// probeClient returns a client sending through base the headers, request body and cookies that the
+// benchmark requests carry, without injected latency or rate limit tracking.
+func (setup *SpeedMeasurement) probeClient(base http.RoundTripper) *http.Client {
+	var transport http.RoundTripper = &HeaderTransport{Base: base, Headers: setup.Headers, AuthToken: setup.ApiKey}
+	if len(setup.RequestBody) > 0 {
+		transport = &api.RequestBodyTransport{Base: transport, Body: setup.RequestBody}
+	}
+	return &http.Client{Transport: transport, Jar: setup.CookieJar, Timeout: setup.Timeout}
 }
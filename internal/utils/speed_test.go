@@ -0,0 +1,218 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+// newStreamServer returns a streaming chat completions server. The nth request it receives waits
+// ttfts[n] before its first token and reports promptTokens[n] prompt tokens, both repeating when
+// there are more requests than values.
+func newStreamServer(tb testing.TB, ttfts []time.Duration, promptTokens []int, completionTokens int) *httptest.Server {
+	var received atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+		n := int(received.Add(1) - 1)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+
+		time.Sleep(ttfts[n%len(ttfts)])
+		for i := 0; i < completionTokens; i++ {
+			fmt.Fprint(w, "data: {\"id\":\"x\",\"object\":\"chat.completion.chunk\",\"model\":\"mock\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"tok \"}}]}\n\n")
+		}
+		fmt.Fprint(w, "data: {\"id\":\"x\",\"object\":\"chat.completion.chunk\",\"model\":\"mock\",\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\n")
+		prompt := promptTokens[n%len(promptTokens)]
+		fmt.Fprintf(w, "data: {\"id\":\"x\",\"object\":\"chat.completion.chunk\",\"model\":\"mock\",\"choices\":[],\"usage\":{\"prompt_tokens\":%d,\"completion_tokens\":%d,\"total_tokens\":%d}}\n\n", prompt, completionTokens, prompt+completionTokens)
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	tb.Cleanup(server.Close)
+	return server
+}
+
+func runAgainst(tb testing.TB, server *httptest.Server, concurrency int) SpeedResult {
+	setup := SpeedMeasurement{
+		BaseUrl:     server.URL,
+		ApiKey:      "test",
+		ModelName:   "mock",
+		Prompt:      "Write a long story.",
+		MaxTokens:   16,
+		Concurrency: concurrency,
+	}
+	result, err := setup.Run(nil)
+	if err != nil {
+		tb.Fatalf("Run: %v", err)
+	}
+	if result.SuccessfulRequests != concurrency {
+		tb.Fatalf("%d of %d requests succeeded", result.SuccessfulRequests, concurrency)
+	}
+	return result
+}
+
+// assertRate checks a rate derived from measured TTFTs. The measured TTFT includes the round trip
+// on top of the server's delay, so the rate may only fall a little short of want.
+func assertRate(t *testing.T, name string, got, want float64) {
+	t.Helper()
+	if got > want*1.01 || got < want*0.8 {
+		t.Errorf("%s = %v, want about %v", name, got, want)
+	}
+}
+
+func TestPrefillRatesFixedTtft(t *testing.T) {
+	const concurrency = 4
+	server := newStreamServer(t, []time.Duration{100 * time.Millisecond}, []int{200}, 8)
+	result := runAgainst(t, server, concurrency)
+
+	if result.TotalPromptTokens != concurrency*200 {
+		t.Errorf("TotalPromptTokens = %d, want %d", result.TotalPromptTokens, concurrency*200)
+	}
+	// Each request prefills 200 tokens in 0.1 s, and all of them do so at the same time
+	assertRate(t, "PrefillSpeed", result.PrefillSpeed, 2000)
+	assertRate(t, "PromptThroughput", result.PromptThroughput, concurrency*2000)
+}
+
+func TestPromptThroughputPerRequestWindows(t *testing.T) {
+	ttfts := []time.Duration{50 * time.Millisecond, 100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond}
+	server := newStreamServer(t, ttfts, []int{100, 200, 200, 400}, 8)
+	result := runAgainst(t, server, len(ttfts))
+
+	// 100/0.05 + 200/0.1 + 200/0.2 + 400/0.4, each request over its own prefill window. Dividing the
+	// 900 prompt tokens by the slowest TTFT instead would give 2250.
+	assertRate(t, "PromptThroughput", result.PromptThroughput, 6000)
+	assertRate(t, "PrefillSpeed", result.PrefillSpeed, 1500)
+}
+
+func TestPromptThroughputSummarize(t *testing.T) {
+	outcomes := newRequestOutcomes(4)
+	copy(outcomes.succeeded, []bool{true, true, true, false})
+	copy(outcomes.ttfts, []float64{0.5, 1, 2, 0})
+	copy(outcomes.promptTokens, []int{100, 400, 400, 400})
+
+	tests := []struct {
+		name                           string
+		include                        []bool
+		latency                        float64
+		promptThroughput, prefillSpeed float64
+	}{
+		// The failed request has no prefill window and is left out
+		{name: "all requests", promptThroughput: 800, prefillSpeed: 266.67},
+		{name: "included requests", include: []bool{false, true, true, true}, promptThroughput: 600, prefillSpeed: 300},
+		// 100 ms is subtracted from the windows it is at most a tenth of, not from the 0.5 s one
+		{name: "latency", latency: 100, promptThroughput: 200 + 400/0.9 + 400/1.9, prefillSpeed: (200 + 400/0.9 + 400/1.9) / 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var result SpeedResult
+			outcomes.summarize(&result, tt.include, time.Second, 4, tt.latency)
+			if math.Abs(result.PromptThroughput-tt.promptThroughput) > 0.01 {
+				t.Errorf("PromptThroughput = %v, want %v", result.PromptThroughput, tt.promptThroughput)
+			}
+			if math.Abs(result.PrefillSpeed-tt.prefillSpeed) > 0.01 {
+				t.Errorf("PrefillSpeed = %v, want %v", result.PrefillSpeed, tt.prefillSpeed)
+			}
+		})
+	}
+}
+
+func TestLatencyAdjustedWindow(t *testing.T) {
+	tests := []struct {
+		name      string
+		window    float64
+		latencyMs float64
+		want      float64
+		ok        bool
+	}{
+		{name: "zero latency", window: 2, latencyMs: 0, want: 2, ok: true},
+		{name: "small latency", window: 2, latencyMs: 50, want: 1.95, ok: true},
+		{name: "at the fraction", window: 2, latencyMs: 2000 * MaxLatencyFraction, want: 2 - 2*MaxLatencyFraction, ok: true},
+		{name: "above the fraction", window: 2, latencyMs: 2000*MaxLatencyFraction + 1, want: 2, ok: false},
+		{name: "latency equals window", window: 0.8, latencyMs: 800, want: 0.8, ok: false},
+		{name: "latency exceeds window", window: 0.2, latencyMs: 800, want: 0.2, ok: false},
+		{name: "negative latency", window: 2, latencyMs: -1, want: 2, ok: false},
+		{name: "empty window", window: 0, latencyMs: 0, want: 0, ok: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := latencyAdjustedWindow(tt.window, tt.latencyMs)
+			if math.Abs(got-tt.want) > 1e-9 || ok != tt.ok {
+				t.Errorf("latencyAdjustedWindow(%v, %v) = %v, %v, want %v, %v", tt.window, tt.latencyMs, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestHighLatencySkipsAdjustment(t *testing.T) {
+	outcomes := newRequestOutcomes(2)
+	copy(outcomes.succeeded, []bool{true, true})
+	copy(outcomes.ttfts, []float64{0.1, 0.1})
+	copy(outcomes.promptTokens, []int{100, 100})
+	copy(outcomes.responseTokens, []int{50, 50})
+
+	// 800 ms of latency against requests and a level that are faster than that
+	var result SpeedResult
+	outcomes.summarize(&result, nil, 500*time.Millisecond, 2, 800)
+	if !result.LatencyAdjustmentSkipped {
+		t.Error("LatencyAdjustmentSkipped = false, want true")
+	}
+	if result.GenerationSpeed != 200 {
+		t.Errorf("GenerationSpeed = %v, want 200 over the unadjusted window", result.GenerationSpeed)
+	}
+	if result.PromptThroughput != 2000 {
+		t.Errorf("PromptThroughput = %v, want 2000 over the unadjusted windows", result.PromptThroughput)
+	}
+}
+
+func TestTokenBarCompletesWithFailures(t *testing.T) {
+	stream := newStreamServer(t, []time.Duration{0}, []int{20}, 8)
+	var received atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Every other request fails before streaming anything
+		if received.Add(1)%2 == 0 {
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		stream.Config.Handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	const concurrency, maxTokens = 4, 16
+	bar := progressbar.NewOptions(concurrency*maxTokens, progressbar.OptionSetWriter(io.Discard))
+	setup := SpeedMeasurement{
+		BaseUrl:     server.URL,
+		ApiKey:      "test",
+		ModelName:   "mock",
+		Prompt:      "Write a long story.",
+		MaxTokens:   maxTokens,
+		Concurrency: concurrency,
+	}
+	if _, err := setup.Run(bar); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	// Two responses of 8 tokens, the failed requests count for nothing
+	if state := bar.State(); state.Max != 16 || state.CurrentNum != 16 {
+		t.Errorf("bar at %d of %d, want 16 of 16", state.CurrentNum, state.Max)
+	}
+}
+
+// BenchmarkRun measures the overhead of a level at high concurrency, where every request goroutine
+// stores its outcome. The server answers at once, so the requests themselves take little time.
+func BenchmarkRun(b *testing.B) {
+	const concurrency = 128
+	server := newStreamServer(b, []time.Duration{0}, []int{20}, 16)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runAgainst(b, server, concurrency)
+	}
+}
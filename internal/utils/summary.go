@@ -0,0 +1,57 @@
+package utils
+
+// SummarizeResults condenses the results of all concurrency levels into one summary row.
+// GenerationSpeed, throughput and average TTFT values are means across levels, with the range of the
+// generation speed in Min/MaxGenerationSpeed. MinTtft and P95Ttft are the best values observed,
+// MaxTtft and P99Ttft the worst. SuccessRate is weighted by the number of requests of each level.
+// Concurrency is 0 to mark the row as a summary.
+func SummarizeResults(results []SpeedResult) SpeedResult {
+	summary := SpeedResult{}
+	if len(results) == 0 {
+		return summary
+	}
+
+	n := float64(len(results))
+	summary.MinGenerationSpeed = results[0].GenerationSpeed
+	summary.MaxGenerationSpeed = results[0].GenerationSpeed
+	summary.MinTtft = results[0].MinTtft
+	summary.P95Ttft = results[0].P95Ttft
+	for _, result := range results {
+		summary.GenerationSpeed += result.GenerationSpeed / n
+		summary.NormalizedGenerationSpeed += result.NormalizedGenerationSpeed / n
+		summary.PromptThroughput += result.PromptThroughput / n
+		summary.TotalThroughput += result.TotalThroughput / n
+		summary.AvgTtft += result.AvgTtft / n
+		summary.MedianTtft += result.MedianTtft / n
+		summary.P75Ttft += result.P75Ttft / n
+		summary.StdDevTtft += result.StdDevTtft / n
+		summary.MinGenerationSpeed = min(summary.MinGenerationSpeed, result.GenerationSpeed)
+		summary.MaxGenerationSpeed = max(summary.MaxGenerationSpeed, result.GenerationSpeed)
+		summary.MinTtft = min(summary.MinTtft, result.MinTtft)
+		summary.MaxTtft = max(summary.MaxTtft, result.MaxTtft)
+		summary.P95Ttft = min(summary.P95Ttft, result.P95Ttft)
+		summary.P99Ttft = max(summary.P99Ttft, result.P99Ttft)
+
+		summary.SuccessfulRequests += result.SuccessfulRequests
+		summary.FailedRequests += result.FailedRequests
+		summary.TotalPromptTokens += result.TotalPromptTokens
+		summary.TotalCompletionTokens += result.TotalCompletionTokens
+		summary.Duration += result.Duration
+	}
+
+	if totalRequests := summary.SuccessfulRequests + summary.FailedRequests; totalRequests > 0 {
+		summary.SuccessRate = float64(summary.SuccessfulRequests) / float64(totalRequests)
+	}
+
+	summary.GenerationSpeed = RoundToPrecision(summary.GenerationSpeed)
+	summary.NormalizedGenerationSpeed = RoundToPrecision(summary.NormalizedGenerationSpeed)
+	summary.PromptThroughput = RoundToPrecision(summary.PromptThroughput)
+	summary.TotalThroughput = RoundToPrecision(summary.TotalThroughput)
+	summary.AvgTtft = RoundToPrecision(summary.AvgTtft)
+	summary.MedianTtft = RoundToPrecision(summary.MedianTtft)
+	summary.P75Ttft = RoundToPrecision(summary.P75Ttft)
+	summary.StdDevTtft = RoundToPrecision(summary.StdDevTtft)
+	summary.Duration = RoundToPrecision(summary.Duration)
+
+	return summary
+}
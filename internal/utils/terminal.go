@@ -0,0 +1,20 @@
+package utils
+
+import (
+	"os"
+
+	"golang.org/x/term"
+)
+
+// DefaultTerminalWidth is assumed when the width of the terminal cannot be determined.
+const DefaultTerminalWidth = 80
+
+// GetTerminalWidth returns the width of the terminal attached to stdout, or stderr if stdout is redirected.
+func GetTerminalWidth() int {
+	for _, f := range []*os.File{os.Stdout, os.Stderr} {
+		if width, _, err := term.GetSize(int(f.Fd())); err == nil && width > 0 {
+			return width
+		}
+	}
+	return DefaultTerminalWidth
+}
@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ThroughputSample is the throughput within one sampling interval of a concurrency level.
+type ThroughputSample struct {
+	Elapsed         float64 `json:"elapsed" yaml:"elapsed"` // Seconds since the level started
+	Tokens          int64   `json:"tokens" yaml:"tokens"`   // Estimated tokens streamed since the level started
+	TokensPerSecond float64 `json:"tokens_per_second" yaml:"tokens-per-second"`
+	ActiveRequests  int     `json:"active_requests" yaml:"active-requests"`
+}
+
+// throughputSampler samples a shared token counter on a ticker. Requests add their streamed
+// tokens to tokens and keep active up to date while they run.
+type throughputSampler struct {
+	tokens atomic.Int64
+	active atomic.Int32
+
+	start   time.Time
+	samples []ThroughputSample
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func startThroughputSampler(interval time.Duration, start time.Time) *throughputSampler {
+	sampler := &throughputSampler{start: start, stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(sampler.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				sampler.sample()
+			case <-sampler.stop:
+				sampler.sample()
+				return
+			}
+		}
+	}()
+	return sampler
+}
+
+func (sampler *throughputSampler) sample() {
+	elapsed := time.Since(sampler.start).Seconds()
+	tokens := sampler.tokens.Load()
+	var previousElapsed float64
+	var previousTokens int64
+	if n := len(sampler.samples); n > 0 {
+		previousElapsed, previousTokens = sampler.samples[n-1].Elapsed, sampler.samples[n-1].Tokens
+	}
+	var rate float64
+	if elapsed > previousElapsed {
+		rate = float64(tokens-previousTokens) / (elapsed - previousElapsed)
+	}
+	sampler.samples = append(sampler.samples, ThroughputSample{
+		Elapsed:         RoundToPrecision(elapsed),
+		Tokens:          tokens,
+		TokensPerSecond: RoundToPrecision(rate),
+		ActiveRequests:  int(sampler.active.Load()),
+	})
+}
+
+// finish takes a last sample and returns the series.
+func (sampler *throughputSampler) finish() []ThroughputSample {
+	close(sampler.stop)
+	<-sampler.done
+	return sampler.samples
+}
@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// TraceEntry is one recorded request. Offset is the time in seconds between the start of the
+// concurrency level and the moment the request was sent.
+type TraceEntry struct {
+	Concurrency int     `json:"concurrency"`
+	Index       int     `json:"index"`
+	Offset      float64 `json:"offset"`
+	Prompt      string  `json:"prompt"`
+	MaxTokens   int     `json:"max_tokens"`
+	NumMessages int     `json:"num_messages"`
+}
+
+// TraceRecorder writes the requests of a benchmark run to a JSON Lines file.
+// Only the first run of each concurrency level is recorded, so repeats do not duplicate the workload.
+type TraceRecorder struct {
+	mu       sync.Mutex
+	file     *os.File
+	recorded map[int]bool
+}
+
+// NewTraceRecorder creates or truncates the trace file at path.
+func NewTraceRecorder(path string) (*TraceRecorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &TraceRecorder{file: file, recorded: make(map[int]bool)}, nil
+}
+
+// begin reports whether the requests of this concurrency level should be recorded.
+func (recorder *TraceRecorder) begin(concurrency int) bool {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if recorder.recorded[concurrency] {
+		return false
+	}
+	recorder.recorded[concurrency] = true
+	return true
+}
+
+func (recorder *TraceRecorder) record(entry TraceEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	_, err = recorder.file.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the trace file.
+func (recorder *TraceRecorder) Close() error {
+	return recorder.file.Close()
+}
+
+// LoadTrace reads a trace written by TraceRecorder and groups it by concurrency level.
+// The entries of each level are sorted by their offset.
+func LoadTrace(path string) (map[int][]TraceEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	trace := make(map[int][]TraceEntry)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry TraceEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		trace[entry.Concurrency] = append(trace[entry.Concurrency], entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, entries := range trace {
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].Offset < entries[j].Offset })
+	}
+	return trace, nil
+}